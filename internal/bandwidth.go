@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/time/rate"
+)
+
+// The burst has to fit the largest read and the largest chunk that the torrent
+// client passes through the rate limiters.
+const rateLimiterBurst = 256 * 1024
+
+// BandwidthWindow overrides the global bandwidth limits between Start and End.
+// Times are in the HH:MM format and a window can span midnight.
+type BandwidthWindow struct {
+	Start         string `mapstructure:"start"`
+	End           string `mapstructure:"end"`
+	DownloadLimit int    `mapstructure:"download_limit"`
+	UploadLimit   int    `mapstructure:"upload_limit"`
+}
+
+// cronSpecs returns the cron specs for the start and the end of the window.
+func (bw *BandwidthWindow) cronSpecs() ([]string, error) {
+	specs := []string{}
+
+	for _, clock := range []string{bw.Start, bw.End} {
+		t, err := time.Parse("15:04", clock)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth schedule time %q", clock)
+		}
+
+		specs = append(specs, fmt.Sprintf("%d %d * * *", t.Minute(), t.Hour()))
+	}
+
+	return specs, nil
+}
+
+func (bw *BandwidthWindow) contains(now time.Time) bool {
+	start, errStart := time.Parse("15:04", bw.Start)
+	end, errEnd := time.Parse("15:04", bw.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := end.Hour()*60 + end.Minute()
+
+	if startMinutes <= endMinutes {
+		return minutes >= startMinutes && minutes < endMinutes
+	}
+
+	return minutes >= startMinutes || minutes < endMinutes
+}
+
+// scheduledBandwidthLimits returns the download and upload limits in KiB/s
+// that apply at the given time.
+func scheduledBandwidthLimits(config *Config, now time.Time) (int, int) {
+	for _, window := range config.BandwidthSchedule {
+		if window.contains(now) {
+			return window.DownloadLimit, window.UploadLimit
+		}
+	}
+
+	return config.DownloadLimit, config.UploadLimit
+}
+
+func newRateLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Inf, rateLimiterBurst)
+}
+
+// setRateLimit sets the limit in KiB/s. Zero removes the limit.
+func setRateLimit(limiter *rate.Limiter, limit int) {
+	if limit <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+
+	limiter.SetLimit(rate.Limit(limit * 1024))
+}
+
+// torrentThrottle keeps the download rate of a single torrent under its limit.
+// The torrent client only supports global rate limits so the data download of
+// the torrent is suspended for as long as it is ahead of its limit.
+type torrentThrottle struct {
+	// limit in bytes per second, zero means unlimited.
+	limit         int64
+	lastUpdate    time.Time
+	lastBytesRead int64
+	excess        float64
+	suspended     bool
+}
+
+func (tt *torrentThrottle) setLimit(limit int) {
+	tt.limit = int64(limit) * 1024
+	tt.excess = 0
+}
+
+func (tt *torrentThrottle) update(t *torrent.Torrent, now time.Time) {
+	stats := t.Stats()
+	bytesRead := stats.BytesReadData.Int64()
+	downloaded := bytesRead - tt.lastBytesRead
+	elapsed := now.Sub(tt.lastUpdate).Seconds()
+
+	tt.lastBytesRead = bytesRead
+	tt.lastUpdate = now
+
+	if tt.limit > 0 {
+		tt.excess = math.Max(0, tt.excess+float64(downloaded)-float64(tt.limit)*elapsed)
+	} else {
+		tt.excess = 0
+	}
+
+	suspend := tt.excess > 0
+	if suspend == tt.suspended {
+		return
+	}
+
+	tt.suspended = suspend
+	if suspend {
+		t.DisallowDataDownload()
+	} else {
+		t.AllowDataDownload()
+	}
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func clock(hour, minute int) time.Time {
+	return time.Date(2021, 6, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestBandwidthWindowContains(t *testing.T) {
+	day := BandwidthWindow{Start: "09:00", End: "17:30"}
+	night := BandwidthWindow{Start: "23:00", End: "07:00"}
+
+	tests := []struct {
+		name     string
+		window   BandwidthWindow
+		now      time.Time
+		contains bool
+	}{
+		{"before", day, clock(8, 59), false},
+		{"start", day, clock(9, 0), true},
+		{"inside", day, clock(12, 0), true},
+		{"end is excluded", day, clock(17, 30), false},
+		{"before midnight", night, clock(23, 30), true},
+		{"after midnight", night, clock(3, 0), true},
+		{"outside a window spanning midnight", night, clock(12, 0), false},
+		{"end of a window spanning midnight", night, clock(7, 0), false},
+		{"invalid", BandwidthWindow{Start: "25:00", End: "07:00"}, clock(3, 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.window.contains(test.now) != test.contains {
+				t.Errorf("%+v contains %s is %t", test.window, test.now.Format("15:04"), !test.contains)
+			}
+		})
+	}
+}
+
+func TestBandwidthWindowCronSpecs(t *testing.T) {
+	window := BandwidthWindow{Start: "01:30", End: "07:00"}
+
+	specs, err := window.cronSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"30 1 * * *", "0 7 * * *"}
+	if !reflect.DeepEqual(specs, expected) {
+		t.Errorf("cron specs are %v, expected %v", specs, expected)
+	}
+
+	window.End = "7am"
+
+	_, err = window.cronSpecs()
+	if err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
+
+func TestScheduledBandwidthLimits(t *testing.T) {
+	config := &Config{
+		DownloadLimit: 1000,
+		UploadLimit:   100,
+		BandwidthSchedule: []BandwidthWindow{
+			{Start: "01:00", End: "07:00", DownloadLimit: 0, UploadLimit: 500},
+			{Start: "06:00", End: "09:00", DownloadLimit: 200, UploadLimit: 50},
+		},
+	}
+
+	tests := []struct {
+		now      time.Time
+		download int
+		upload   int
+	}{
+		{clock(0, 30), 1000, 100},
+		{clock(3, 0), 0, 500},
+		// The first matching window wins.
+		{clock(6, 30), 0, 500},
+		{clock(8, 0), 200, 50},
+		{clock(12, 0), 1000, 100},
+	}
+
+	for _, test := range tests {
+		download, upload := scheduledBandwidthLimits(config, test.now)
+		if download != test.download || upload != test.upload {
+			t.Errorf("limits at %s are %d/%d, expected %d/%d", test.now.Format("15:04"), download, upload, test.download, test.upload)
+		}
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	limiter := newRateLimiter()
+
+	setRateLimit(limiter, 100)
+	if limiter.Limit() != rate.Limit(100*1024) {
+		t.Errorf("limit is %v, expected 100 KiB/s", limiter.Limit())
+	}
+
+	setRateLimit(limiter, 0)
+	if limiter.Limit() != rate.Inf {
+		t.Errorf("limit is %v, expected no limit", limiter.Limit())
+	}
+}
+
+func TestTorrentManagerSetDownloadLimit(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	err := torrentManager.SetDownloadLimit("unknown", 100)
+	if err == nil {
+		t.Error("expected an error for a torrent that isn't downloading")
+	}
+
+	added, err := torrentManager.AddMagnet("magnet:?xt=urn:btih:0000000000000000000000000000000000000001", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = torrentManager.SetDownloadLimit(added.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrent, err := torrentManager.database.TorrentWithID(added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if torrent.DownloadLimit != 100 {
+		t.Errorf("saved limit is %d, expected 100", torrent.DownloadLimit)
+	}
+
+	torrentManager.mutex.Lock()
+	limit := torrentManager.activeTorrents[added.ID].throttle.limit
+	torrentManager.mutex.Unlock()
+
+	if limit != 100*1024 {
+		t.Errorf("throttle limit is %d, expected 100 KiB/s", limit)
+	}
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"piflix/internal/model"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// ipBlocklist is handed to the torrent client once, its ranges can be
+// replaced while the client is running. Only IPv4 ranges are supported.
+type ipBlocklist struct {
+	mutex  sync.RWMutex
+	ranges *iplist.IPList
+	hits   int64
+
+	blockedMutex sync.Mutex
+	blocked      map[string]struct{}
+}
+
+// Lookup is called by the torrent client before it accepts a connection and
+// before it adds a peer it learned about from trackers, PEX or the DHT to the
+// peers it dials. The client doesn't tell these apart and the same address is
+// looked up again and again, so besides the hits the distinct blocked
+// addresses are counted.
+func (b *ipBlocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	b.mutex.RLock()
+	r, ok := b.ranges.Lookup(ip)
+	b.mutex.RUnlock()
+
+	if ok {
+		atomic.AddInt64(&b.hits, 1)
+
+		b.blockedMutex.Lock()
+		if b.blocked == nil {
+			b.blocked = map[string]struct{}{}
+		}
+		b.blocked[ip.String()] = struct{}{}
+		b.blockedMutex.Unlock()
+	}
+
+	return r, ok
+}
+
+func (b *ipBlocklist) NumRanges() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.ranges.NumRanges()
+}
+
+func (b *ipBlocklist) Stats() model.BlocklistStats {
+	b.blockedMutex.Lock()
+	blockedAddresses := len(b.blocked)
+	b.blockedMutex.Unlock()
+
+	return model.BlocklistStats{
+		Ranges:           b.NumRanges(),
+		Hits:             atomic.LoadInt64(&b.hits),
+		BlockedAddresses: blockedAddresses,
+	}
+}
+
+// load replaces the ranges with the ones from a P2P or eMule format file,
+// which can be gzipped.
+func (b *ipBlocklist) load(path string) error {
+	ranges, err := readBlocklist(path)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	b.ranges = iplist.New(ranges)
+	b.mutex.Unlock()
+
+	log.Println("Loaded", len(ranges), "blocked IP ranges from", path)
+
+	return nil
+}
+
+func readBlocklist(path string) ([]iplist.Range, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	var input io.Reader = reader
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+
+		input = gzipReader
+	}
+
+	ranges := []iplist.Range{}
+	invalidLines := 0
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		r, ok, err := parseBlocklistLine(scanner.Text())
+		if err != nil {
+			invalidLines++
+			continue
+		}
+
+		if ok {
+			ranges = append(ranges, r)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ranges) == 0 && invalidLines > 0 {
+		return nil, errors.New("no valid IP ranges found")
+	}
+
+	if invalidLines > 0 {
+		log.Println("Skipped", invalidLines, "invalid lines in blocklist", path)
+	}
+
+	return mergeRanges(ranges), nil
+}
+
+// parseBlocklistLine parses a line in the P2P format, "description:first-last",
+// or in the eMule format, "first - last , level , description". It returns
+// false for comments, empty lines and eMule ranges whose level allows them.
+func parseBlocklistLine(line string) (iplist.Range, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return iplist.Range{}, false, nil
+	}
+
+	var description, ips string
+
+	if fields := strings.Split(line, ","); len(fields) >= 2 {
+		level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return iplist.Range{}, false, err
+		}
+
+		if level >= 128 {
+			return iplist.Range{}, false, nil
+		}
+
+		ips = fields[0]
+		if len(fields) >= 3 {
+			description = strings.TrimSpace(strings.Join(fields[2:], ","))
+		}
+	} else {
+		colon := strings.LastIndex(line, ":")
+		if colon == -1 {
+			return iplist.Range{}, false, errors.New("missing colon")
+		}
+
+		description = line[:colon]
+		ips = line[colon+1:]
+	}
+
+	hyphen := strings.Index(ips, "-")
+	if hyphen == -1 {
+		return iplist.Range{}, false, errors.New("missing hyphen")
+	}
+
+	first := parseIPv4(ips[:hyphen])
+	last := parseIPv4(ips[hyphen+1:])
+	if first == nil || last == nil || bytes.Compare(first, last) > 0 {
+		return iplist.Range{}, false, fmt.Errorf("bad IP range %q", ips)
+	}
+
+	return iplist.Range{First: first, Last: last, Description: description}, true, nil
+}
+
+// parseIPv4 accepts the zero padded addresses that are used by eMule lists.
+func parseIPv4(s string) net.IP {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) != 4 {
+		return nil
+	}
+
+	ip := make(net.IP, 4)
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 || value > 255 {
+			return nil
+		}
+
+		ip[i] = byte(value)
+	}
+
+	return ip
+}
+
+// mergeRanges sorts the ranges and merges the overlapping ones, which the
+// lookup in iplist expects.
+func mergeRanges(ranges []iplist.Range) []iplist.Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].First, ranges[j].First) < 0
+	})
+
+	merged := []iplist.Range{}
+
+	for _, r := range ranges {
+		if len(merged) > 0 {
+			previous := &merged[len(merged)-1]
+			if bytes.Compare(r.First, nextIP(previous.Last)) <= 0 {
+				if bytes.Compare(r.Last, previous.Last) > 0 {
+					previous.Last = r.Last
+				}
+
+				continue
+			}
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP{}, ip...)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+
+	// The last address, nothing can follow it.
+	return ip
+}
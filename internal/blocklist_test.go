@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"net"
+	"testing"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+func TestIPBlocklistStats(t *testing.T) {
+	blocklist := &ipBlocklist{
+		ranges: iplist.New([]iplist.Range{{First: net.IPv4(10, 0, 0, 0).To4(), Last: net.IPv4(10, 0, 0, 255).To4()}}),
+	}
+
+	for _, ip := range []net.IP{
+		net.IPv4(10, 0, 0, 1),
+		net.IPv4(10, 0, 0, 1),
+		net.IPv4(10, 0, 0, 2),
+		net.IPv4(192, 168, 0, 1),
+	} {
+		blocklist.Lookup(ip)
+	}
+
+	stats := blocklist.Stats()
+	if stats.Ranges != 1 || stats.Hits != 3 || stats.BlockedAddresses != 2 {
+		t.Errorf("stats are %+v, expected 1 range, 3 hits and 2 blocked addresses", stats)
+	}
+}
@@ -1,7 +1,13 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"piflix/internal/hls"
+	"piflix/internal/utility"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -9,9 +15,45 @@ import (
 type Config struct {
 	WorkDir     string `mapstructure:"work_dir"`
 	FfmpegPath  string `mapstructure:"ffmpeg_path"`
+	FfprobePath string `mapstructure:"ffprobe_path"`
 	FFmpegPI    bool   `mapstructure:"ffmpeg_pi"`
 	LogPath     string `mapstructure:"log_path"`
 	Resolutions string `mapstructure:"resolutions"`
+
+	// RenderWorkers is the number of torrents that are rendered at once.
+	RenderWorkers int `mapstructure:"render_workers"`
+
+	// A failed render is retried this many times, the delay doubles after
+	// every attempt.
+	RenderRetries    int           `mapstructure:"render_retries"`
+	RenderRetryDelay time.Duration `mapstructure:"render_retry_delay"`
+
+	MetadataTimeout    time.Duration `mapstructure:"metadata_timeout"`
+	MaxActiveDownloads int           `mapstructure:"max_active_downloads"`
+
+	// Bandwidth limits are in KiB/s, zero means unlimited.
+	DownloadLimit     int               `mapstructure:"download_limit"`
+	UploadLimit       int               `mapstructure:"upload_limit"`
+	BandwidthSchedule []BandwidthWindow `mapstructure:"bandwidth_schedule"`
+
+	// Completed torrents are seeded until both the ratio and the time goal
+	// are reached.
+	Seeding            bool          `mapstructure:"seeding"`
+	SeedRatio          float64       `mapstructure:"seed_ratio"`
+	SeedTime           time.Duration `mapstructure:"seed_time"`
+	MaxSeedingTorrents int           `mapstructure:"max_seeding_torrents"`
+
+	// Downloads and renders are paused while there is less free space in
+	// the working directory, in MiB.
+	MinFreeSpace int `mapstructure:"min_free_space"`
+
+	// Torrent and magnet files put into the watch directory are added
+	// automatically.
+	WatchDir string `mapstructure:"watch_dir"`
+
+	FeedInterval time.Duration `mapstructure:"feed_interval"`
+
+	Torrent TorrentConfig `mapstructure:"torrent"`
 }
 
 func LoadConfig(path string) *Config {
@@ -19,6 +61,19 @@ func LoadConfig(path string) *Config {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(path)
 
+	viper.SetDefault("metadata_timeout", "10m")
+	viper.SetDefault("render_workers", 1)
+	viper.SetDefault("render_retries", 3)
+	viper.SetDefault("render_retry_delay", "1m")
+	viper.SetDefault("min_free_space", 1024)
+	viper.SetDefault("feed_interval", "15m")
+	viper.SetDefault("torrent.listen_port", 42069)
+	viper.SetDefault("torrent.dht", true)
+	viper.SetDefault("torrent.pex", true)
+	viper.SetDefault("torrent.utp", true)
+	viper.SetDefault("torrent.ipv6", true)
+	viper.SetDefault("torrent.encryption", encryptionPreferred)
+
 	err := viper.ReadInConfig()
 	if err != nil {
 		panic(fmt.Errorf("couldn't load config file: %s", err))
@@ -31,5 +86,74 @@ func LoadConfig(path string) *Config {
 		panic(fmt.Errorf("couldn't load read file: %s", err))
 	}
 
+	if len(config.FfprobePath) == 0 {
+		config.FfprobePath = ffprobeNextToFfmpeg(config.FfmpegPath)
+	}
+
+	err = config.validate()
+	if err != nil {
+		panic(fmt.Errorf("invalid config: %s", err))
+	}
+
 	return config
 }
+
+// ffprobeNextToFfmpeg assumes that ffprobe is installed in the same way as
+// ffmpeg.
+func ffprobeNextToFfmpeg(ffmpegPath string) string {
+	dir, name := filepath.Split(ffmpegPath)
+
+	return dir + strings.Replace(name, "ffmpeg", "ffprobe", 1)
+}
+
+func (c *Config) validate() error {
+	for _, res := range strings.Split(utility.StripSpaces(c.Resolutions), ",") {
+		if !hls.HasPreset(res) {
+			return fmt.Errorf("unknown resolution %q", res)
+		}
+	}
+
+	if c.RenderWorkers < 1 {
+		return errors.New("at least one render worker is needed")
+	}
+
+	if c.RenderRetries < 0 || c.RenderRetryDelay < 0 {
+		return errors.New("render retries can't be negative")
+	}
+
+	if c.MaxActiveDownloads < 0 {
+		return errors.New("max active downloads can't be negative")
+	}
+
+	if c.DownloadLimit < 0 || c.UploadLimit < 0 {
+		return errors.New("bandwidth limits can't be negative")
+	}
+
+	for _, window := range c.BandwidthSchedule {
+		if _, err := window.cronSpecs(); err != nil {
+			return err
+		}
+
+		if window.DownloadLimit < 0 || window.UploadLimit < 0 {
+			return errors.New("bandwidth limits can't be negative")
+		}
+	}
+
+	if c.Seeding && c.SeedRatio <= 0 && c.SeedTime <= 0 {
+		return errors.New("seeding needs a seed ratio or a seed time")
+	}
+
+	if c.SeedRatio < 0 || c.SeedTime < 0 || c.MaxSeedingTorrents < 0 {
+		return errors.New("seeding goals can't be negative")
+	}
+
+	if c.MinFreeSpace < 0 {
+		return errors.New("min free space can't be negative")
+	}
+
+	if c.FeedInterval < time.Minute {
+		return errors.New("feed interval can't be shorter than a minute")
+	}
+
+	return c.Torrent.validate()
+}
@@ -2,15 +2,27 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
 	"piflix/internal/model"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const CURRENT_DB_VERSION int = 1
+const CURRENT_DB_VERSION int = 14
+
+const feedColumns = "id, name, url, enabled, last_checked, last_error"
+
+const feedRuleColumns = "id, feed_id, name, include, exclude, quality, min_size, max_size"
+
+const renderJobColumns = "id, torrent_id, status, attempts, error, created_time, run_after, started_time, finished_time"
+
+const torrentEventColumns = "id, torrent_id, status, time, message"
+
+const torrentColumns = "id, hash, name, magnet, status, added_time, poster, metainfo, failure, select_files, download_limit, seeding, seeding_time, uploaded, queue_position, resolutions"
 
 type SQLite struct {
 	db *sql.DB
@@ -40,10 +52,15 @@ func NewSQLiteDatabase(workDir string) *SQLite {
 // Managing models
 
 func (sqlite *SQLite) SaveTorrent(t *model.Torrent) error {
-	_, err := sqlite.db.Exec("INSERT INTO torrent(id, hash, name, magnet, status, added_time) VALUES (?, ?, ?, ?, ?, ?)", t.ID, t.Hash, t.Name, t.Magnet, t.Status, t.AddedTime)
+	_, err := sqlite.db.Exec("INSERT INTO torrent(id, hash, name, magnet, status, added_time, metainfo, select_files) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", t.ID, t.Hash, t.Name, t.Magnet, t.Status, t.AddedTime, t.Metainfo, t.SelectFiles)
+	if err != nil {
+		return err
+	}
 
 	sqlite.saveTorrentFiles(t.Files)
 
+	_, err = sqlite.db.Exec("INSERT INTO torrent_event(torrent_id, status, time) VALUES (?, ?, ?)", t.ID, t.Status, t.AddedTime)
+
 	return err
 }
 
@@ -51,20 +68,67 @@ func (sqlite *SQLite) GetDownloadingTorrents() ([]model.Torrent, error) {
 	return sqlite.getTorrentWithStatus(model.TorrentStatusDownloading)
 }
 
+func (sqlite *SQLite) GetPausedTorrents() ([]model.Torrent, error) {
+	return sqlite.getTorrentWithStatus(model.TorrentStatusPaused)
+}
+
+func (sqlite *SQLite) GetSelectingFilesTorrents() ([]model.Torrent, error) {
+	return sqlite.getTorrentWithStatus(model.TorrentStatusSelectingFiles)
+}
+
+func (sqlite *SQLite) GetFetchingMetadataTorrents() ([]model.Torrent, error) {
+	return sqlite.getTorrentWithStatus(model.TorrentStatusFetchingMetadata)
+}
+
+func (sqlite *SQLite) GetFailedTorrents() ([]model.Torrent, error) {
+	return sqlite.getTorrentWithStatus(model.TorrentStatusFailed)
+}
+
 func (sqlite *SQLite) GetRenderingTorrents() ([]model.Torrent, error) {
 	return sqlite.getTorrentWithStatus(model.TorrentStatusRendering)
 }
 
+func (sqlite *SQLite) GetRenderFailedTorrents() ([]model.Torrent, error) {
+	return sqlite.getTorrentWithStatus(model.TorrentStatusRenderFailed)
+}
+
 func (sqlite *SQLite) GetDownloadedTorrents() ([]model.Torrent, error) {
 	return sqlite.getTorrentWithStatus(model.TorrentStatusReady)
 }
 
-func (sqlite *SQLite) TorrentWithID(ID string) (*model.Torrent, error) {
-	torrent := model.Torrent{}
+// GetQueuedTorrents returns the queued torrents in the order they will be
+// started.
+func (sqlite *SQLite) GetQueuedTorrents() ([]model.Torrent, error) {
+	rows, err := sqlite.db.Query("SELECT "+torrentColumns+" FROM torrent WHERE status = ? ORDER BY queue_position", model.TorrentStatusQueued)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlite.scanTorrents(rows)
+}
+
+func (sqlite *SQLite) GetSeedingTorrents() ([]model.Torrent, error) {
+	rows, err := sqlite.db.Query("SELECT " + torrentColumns + " FROM torrent WHERE seeding = 1")
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlite.scanTorrents(rows)
+}
 
-	row := sqlite.db.QueryRow("SELECT id, hash, name, magnet, status, added_time, poster FROM torrent WHERE id = ?", ID)
+func (sqlite *SQLite) IsTorrentSeeding(ID string) bool {
+	seeding := false
 
-	err := row.Scan(&torrent.ID, &torrent.Hash, &torrent.Name, &torrent.Magnet, &torrent.Status, &torrent.AddedTime, &torrent.Poster)
+	row := sqlite.db.QueryRow("SELECT seeding FROM torrent WHERE id = ?", ID)
+	row.Scan(&seeding)
+
+	return seeding
+}
+
+func (sqlite *SQLite) TorrentWithID(ID string) (*model.Torrent, error) {
+	row := sqlite.db.QueryRow("SELECT "+torrentColumns+" FROM torrent WHERE id = ?", ID)
+
+	torrent, err := scanTorrent(row)
 	if err != nil {
 		return nil, err
 	}
@@ -74,30 +138,29 @@ func (sqlite *SQLite) TorrentWithID(ID string) (*model.Torrent, error) {
 		return nil, err
 	}
 
-	return &torrent, nil
+	return torrent, nil
 }
 
 func (sqlite *SQLite) TorrentWithHash(hash string) (*model.Torrent, error) {
-	torrent := model.Torrent{}
-
-	row := sqlite.db.QueryRow("SELECT id, hash, name, magnet, status, added_time, poster FROM torrent WHERE hash = ?", hash)
+	row := sqlite.db.QueryRow("SELECT "+torrentColumns+" FROM torrent WHERE hash = ?", hash)
 
-	err := row.Scan(&torrent.ID, &torrent.Hash, &torrent.Name, &torrent.Magnet, &torrent.Status, &torrent.AddedTime, &torrent.Poster)
+	torrent, err := scanTorrent(row)
 	if err != nil {
 		return nil, err
 	}
 
-	torrent.Files, _ = sqlite.getFilesForTorrentID(torrent.ID)
-
+	torrent.Files, err = sqlite.getFilesForTorrentID(torrent.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &torrent, nil
+	return torrent, nil
 }
 
 func (sqlite *SQLite) DeleteTorrent(torrent *model.Torrent) error {
 	sqlite.deleteTorrentFiles(torrent.ID)
+	sqlite.db.Exec("DELETE FROM torrent_event WHERE torrent_id = ?", torrent.ID)
+	sqlite.db.Exec("DELETE FROM render_job WHERE torrent_id = ?", torrent.ID)
 
 	_, err := sqlite.db.Exec("DELETE FROM torrent where id = ?", torrent.ID)
 
@@ -105,179 +168,1079 @@ func (sqlite *SQLite) DeleteTorrent(torrent *model.Torrent) error {
 }
 
 func (sqlite *SQLite) DeleteFile(file *model.File) error {
+	sqlite.deleteMediaInfo(file.ID)
+
 	_, err := sqlite.db.Exec("DELETE FROM file where id = ?", file.ID)
 
 	return err
 }
 
 func (sqlite *SQLite) SetStatusForTorrent(status model.TorrentStatus, ID string) error {
-	_, err := sqlite.db.Exec("UPDATE torrent SET status = ? WHERE id = ?", status, ID)
+	return sqlite.changeTorrentStatus(status, "", ID, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE torrent SET status = ? WHERE id = ?", status, ID)
 
-	return err
+		return err
+	})
 }
 
-func (sqlite *SQLite) SetImagePathForTorrent(path string, ID string) error {
-	_, err := sqlite.db.Exec("UPDATE torrent SET poster = ? WHERE id = ?", path, ID)
+// EnqueueTorrent puts the torrent at the end of the download queue.
+func (sqlite *SQLite) EnqueueTorrent(ID string) error {
+	return sqlite.changeTorrentStatus(model.TorrentStatusQueued, "", ID, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE torrent SET status = ?, queue_position = (SELECT COALESCE(MAX(queue_position), 0) + 1 FROM torrent WHERE status = ?) WHERE id = ?", model.TorrentStatusQueued, model.TorrentStatusQueued, ID)
 
-	return err
+		return err
+	})
 }
 
-func (sqlite *SQLite) SetSubtitlePathForFile(subtitle string, ID int64) error {
-	_, err := sqlite.db.Exec("UPDATE file SET subtitle = ? WHERE id = ?", subtitle, ID)
+// MoveTorrentInQueue moves the torrent to the given 1-based position in the
+// download queue and renumbers the rest of the queue.
+func (sqlite *SQLite) MoveTorrentInQueue(ID string, position int) error {
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	return err
-}
+	rows, err := tx.Query("SELECT id FROM torrent WHERE status = ? ORDER BY queue_position", model.TorrentStatusQueued)
+	if err != nil {
+		return err
+	}
 
-func (sqlite *SQLite) FileWithID(ID int64) (*model.File, error) {
-	file := model.File{}
+	queue := []string{}
+	found := false
+
+	for rows.Next() {
+		var queuedID string
+		if err := rows.Scan(&queuedID); err != nil {
+			rows.Close()
+			return err
+		}
 
-	row := sqlite.db.QueryRow("SELECT id, path, subtitle, torrent_id FROM file WHERE id = ?", ID)
+		if queuedID == ID {
+			found = true
+			continue
+		}
 
-	err := row.Scan(&file.ID, &file.Path, &file.Subtitle, &file.TorrentID)
-	if err != nil {
-		log.Println("File scan failed. Reason:", err)
-		return nil, err
+		queue = append(queue, queuedID)
 	}
+	rows.Close()
 
-	return &file, nil
+	if !found {
+		return errors.New("torrent is not queued")
+	}
+
+	index := position - 1
+	if index < 0 {
+		index = 0
+	} else if index > len(queue) {
+		index = len(queue)
+	}
+
+	queue = append(queue[:index], append([]string{ID}, queue[index:]...)...)
+
+	for index, queuedID := range queue {
+		_, err = tx.Exec("UPDATE torrent SET queue_position = ? WHERE id = ?", index+1, queuedID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (sqlite *SQLite) getTorrentWithStatus(status model.TorrentStatus) ([]model.Torrent, error) {
-	rows, err := sqlite.db.Query("SELECT id, hash, name, magnet, status, added_time, poster FROM torrent WHERE status = ?", status)
+func (sqlite *SQLite) SetFailureForTorrent(reason string, ID string) error {
+	return sqlite.setFailureForTorrent(model.TorrentStatusFailed, reason, ID)
+}
+
+// SetRenderFailureForTorrent marks a downloaded torrent whose rendering
+// failed. Unlike failed torrents it can be rendered again.
+func (sqlite *SQLite) SetRenderFailureForTorrent(reason string, ID string) error {
+	return sqlite.setFailureForTorrent(model.TorrentStatusRenderFailed, reason, ID)
+}
+
+// GetEventsForTorrent returns the status history of a torrent, oldest first.
+func (sqlite *SQLite) GetEventsForTorrent(ID string) ([]model.TorrentEvent, error) {
+	rows, err := sqlite.db.Query("SELECT "+torrentEventColumns+" FROM torrent_event WHERE torrent_id = ? ORDER BY time, id", ID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	torrents := []model.Torrent{}
+	events := []model.TorrentEvent{}
 
 	for rows.Next() {
-		var torrent model.Torrent
-
-		err := rows.Scan(&torrent.ID, &torrent.Hash, &torrent.Name, &torrent.Magnet, &torrent.Status, &torrent.AddedTime, &torrent.Poster)
-		if err != nil {
-			log.Println("Torrent scan failed. Reason:", err)
-			continue
-		}
+		var event model.TorrentEvent
 
-		torrent.Files, err = sqlite.getFilesForTorrentID(torrent.ID)
+		err := rows.Scan(&event.ID, &event.TorrentID, &event.Status, &event.Time, &event.Message)
 		if err != nil {
+			log.Println("Torrent event scan failed. Reason:", err)
 			continue
 		}
 
-		torrents = append(torrents, torrent)
+		events = append(events, event)
 	}
 
-	return torrents, nil
+	return events, nil
 }
 
-func (sqlite *SQLite) saveTorrentFiles(files []model.File) {
-	for _, file := range files {
-		sqlite.db.Exec("INSERT INTO file(path, torrent_id, subtitle) VALUES (?, ?, ?)", file.Path, file.TorrentID, file.Subtitle)
+// SetInfoForTorrent stores the details that are only known once the torrent
+// metadata is resolved.
+func (sqlite *SQLite) SetInfoForTorrent(name string, files []model.File, ID string) error {
+	_, err := sqlite.db.Exec("UPDATE torrent SET name = ? WHERE id = ?", name, ID)
+	if err != nil {
+		return err
 	}
+
+	sqlite.saveTorrentFiles(files)
+
+	return nil
 }
 
-func (sqlite *SQLite) deleteTorrentFiles(ID string) {
-	sqlite.db.Exec("DELETE FROM file WHERE torrent_id = ?", ID)
+func (sqlite *SQLite) SetResolutionsForTorrent(resolutions string, ID string) error {
+	_, err := sqlite.db.Exec("UPDATE torrent SET resolutions = ? WHERE id = ?", resolutions, ID)
+
+	return err
 }
 
-func (sqlite *SQLite) getFilesForTorrentID(ID string) ([]model.File, error) {
-	rows, err := sqlite.db.Query("SELECT id, path, subtitle FROM file WHERE torrent_id = ?", ID)
+func (sqlite *SQLite) SetDownloadLimitForTorrent(limit int, ID string) error {
+	_, err := sqlite.db.Exec("UPDATE torrent SET download_limit = ? WHERE id = ?", limit, ID)
+
+	return err
+}
+
+func (sqlite *SQLite) SetSeedingForTorrent(seeding bool, ID string) error {
+	_, err := sqlite.db.Exec("UPDATE torrent SET seeding = ? WHERE id = ?", seeding, ID)
+
+	return err
+}
+
+func (sqlite *SQLite) SetSeedingStatsForTorrent(seedingTime int64, uploaded int64, ID string) error {
+	_, err := sqlite.db.Exec("UPDATE torrent SET seeding_time = ?, uploaded = ? WHERE id = ?", seedingTime, uploaded, ID)
+
+	return err
+}
+
+func (sqlite *SQLite) SetImagePathForTorrent(path string, ID string) error {
+	_, err := sqlite.db.Exec("UPDATE torrent SET poster = ? WHERE id = ?", path, ID)
+
+	return err
+}
+
+// SetSelectedFilesForTorrent marks the given files as selected for download and
+// all the other files of the torrent as not selected.
+func (sqlite *SQLite) SetSelectedFilesForTorrent(fileIDs []int64, ID string) error {
+	tx, err := sqlite.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	files := []model.File{}
-
-	for rows.Next() {
-		var file model.File
+	_, err = tx.Exec("UPDATE file SET selected = 0 WHERE torrent_id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-		err := rows.Scan(&file.ID, &file.Path, &file.Subtitle)
+	for _, fileID := range fileIDs {
+		_, err = tx.Exec("UPDATE file SET selected = 1 WHERE id = ? AND torrent_id = ?", fileID, ID)
 		if err != nil {
-			log.Println("File scan failed. Reason:", err)
-			continue
+			tx.Rollback()
+			return err
 		}
-
-		files = append(files, file)
 	}
 
-	return files, nil
+	return tx.Commit()
 }
 
-// Helper functions
+func (sqlite *SQLite) DeleteUnselectedFiles(ID string) error {
+	_, err := sqlite.db.Exec("DELETE FROM file WHERE torrent_id = ? AND selected = 0", ID)
 
-func (sqlite *SQLite) migrate() error {
-	version := sqlite.dbVersion()
+	return err
+}
 
-	switch version {
-	case 0:
-		err := migrateToVersion1(sqlite.db)
+func (sqlite *SQLite) SetSubtitlePathForFile(subtitle string, ID int64) error {
+	_, err := sqlite.db.Exec("UPDATE file SET subtitle = ? WHERE id = ?", subtitle, ID)
+
+	return err
+}
+
+// SaveMediaInfo replaces the probed media info of a file.
+func (sqlite *SQLite) SaveMediaInfo(info *model.MediaInfo, fileID int64) error {
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{"DELETE FROM media_stream WHERE file_id = ?", "DELETE FROM media_info WHERE file_id = ?"} {
+		_, err = tx.Exec(query, fileID)
 		if err != nil {
 			return err
 		}
-		fallthrough
-	default:
-		log.Println("Database is fully migrated.")
 	}
 
-	sqlite.setDBVersion(CURRENT_DB_VERSION)
+	video := info.Video
 
-	return nil
+	_, err = tx.Exec("INSERT INTO media_info(file_id, duration, bit_rate, container, video_index, video_codec, width, height, frame_rate, pixel_format, hdr) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", fileID, info.Duration, info.BitRate, info.Container, video.Index, video.Codec, video.Width, video.Height, video.FrameRate, video.PixelFormat, video.HDR)
+	if err != nil {
+		return err
+	}
+
+	for streamType, streams := range map[string][]model.MediaStream{"audio": info.Audio, "subtitle": info.Subtitles} {
+		for _, stream := range streams {
+			_, err = tx.Exec("INSERT INTO media_stream(file_id, stream_index, type, codec, language, title, channels, is_default) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", fileID, stream.Index, streamType, stream.Codec, stream.Language, stream.Title, stream.Channels, stream.Default)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (sqlite *SQLite) dbVersion() int {
-	var userVersion int = 0
-	rows, err := sqlite.db.Query("PRAGMA user_version")
+// MediaInfoForFile returns sql.ErrNoRows if the file wasn't probed.
+func (sqlite *SQLite) MediaInfoForFile(fileID int64) (*model.MediaInfo, error) {
+	info := model.MediaInfo{
+		Audio:     []model.MediaStream{},
+		Subtitles: []model.MediaStream{},
+	}
+	video := &info.Video
+
+	row := sqlite.db.QueryRow("SELECT duration, bit_rate, container, video_index, video_codec, width, height, frame_rate, pixel_format, hdr FROM media_info WHERE file_id = ?", fileID)
+
+	err := row.Scan(&info.Duration, &info.BitRate, &info.Container, &video.Index, &video.Codec, &video.Width, &video.Height, &video.FrameRate, &video.PixelFormat, &video.HDR)
 	if err != nil {
-		return userVersion
+		return nil, err
 	}
 
+	rows, err := sqlite.db.Query("SELECT stream_index, type, codec, language, title, channels, is_default FROM media_stream WHERE file_id = ? ORDER BY stream_index", fileID)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		rows.Scan(&userVersion)
+		var stream model.MediaStream
+		var streamType string
+
+		err := rows.Scan(&stream.Index, &streamType, &stream.Codec, &stream.Language, &stream.Title, &stream.Channels, &stream.Default)
+		if err != nil {
+			log.Println("Media stream scan failed. Reason:", err)
+			continue
+		}
+
+		if streamType == "audio" {
+			info.Audio = append(info.Audio, stream)
+		} else {
+			info.Subtitles = append(info.Subtitles, stream)
+		}
 	}
 
-	return userVersion
+	return &info, nil
 }
 
-func (sqlite *SQLite) setDBVersion(version int) {
-	_, err := sqlite.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+func (sqlite *SQLite) FileWithID(ID int64) (*model.File, error) {
+	file := model.File{}
+
+	row := sqlite.db.QueryRow("SELECT id, path, length, selected, subtitle, torrent_id FROM file WHERE id = ?", ID)
+
+	err := row.Scan(&file.ID, &file.Path, &file.Length, &file.Selected, &file.Subtitle, &file.TorrentID)
 	if err != nil {
-		log.Println("Error setting database user version. Error:", err)
+		log.Println("File scan failed. Reason:", err)
+		return nil, err
 	}
+
+	return &file, nil
 }
 
-// Individual migrations
+// EnqueueRenderJob adds a render job for the torrent unless it already has one
+// that is queued or running.
+func (sqlite *SQLite) EnqueueRenderJob(torrentID string) error {
+	now := time.Now().UTC()
 
-func migrateToVersion1(db *sql.DB) error {
-	err := createTorrent(db)
+	_, err := sqlite.db.Exec("INSERT INTO render_job(torrent_id, status, created_time, run_after) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM render_job WHERE torrent_id = ? AND status IN (?, ?))", torrentID, model.RenderJobStatusQueued, now, now, torrentID, model.RenderJobStatusQueued, model.RenderJobStatusRunning)
+
+	return err
+}
+
+// NextRenderJob marks the first queued job that is due as running and returns
+// it, sql.ErrNoRows if there is none. Times of render jobs are stored in UTC
+// so that they can be compared in queries.
+func (sqlite *SQLite) NextRenderJob() (*model.RenderJob, error) {
+	now := time.Now().UTC()
+
+	tx, err := sqlite.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	err = createFiles(db)
+	row := tx.QueryRow("SELECT "+renderJobColumns+" FROM render_job WHERE status = ? AND run_after <= ? ORDER BY run_after, id LIMIT 1", model.RenderJobStatusQueued, now)
 
-	return err
-}
+	job, err := scanRenderJob(row)
+	if err != nil {
+		return nil, err
+	}
 
-// Table creation helpers
+	job.Status = model.RenderJobStatusRunning
+	job.Attempts++
+	job.StartedTime = &now
 
-func createTorrent(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE torrent (id TEXT PRIMARY KEY, hash TEXT, name TEXT, magnet TEXT, status INTEGER, added_time DATETIME, poster TEXT)")
+	_, err = tx.Exec("UPDATE render_job SET status = ?, attempts = ?, started_time = ? WHERE id = ?", job.Status, job.Attempts, now, job.ID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = db.Exec("CREATE INDEX idx_torrent_status ON torrent(status)")
+	return job, tx.Commit()
+}
+
+// RetryRenderJob puts a failed job back into the queue, it isn't started
+// before runAfter.
+func (sqlite *SQLite) RetryRenderJob(failure string, runAfter time.Time, ID int64) error {
+	_, err := sqlite.db.Exec("UPDATE render_job SET status = ?, error = ?, run_after = ? WHERE id = ?", model.RenderJobStatusQueued, failure, runAfter.UTC(), ID)
 
 	return err
 }
 
-func createFiles(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE file (id INTEGER PRIMARY KEY, path TEXT, subtitle TEXT, torrent_id TEXT, FOREIGN KEY (torrent_id) REFERENCES torrent(id))")
+// FinishRenderJob sets the final status of a job, an empty failure means it
+// succeeded.
+func (sqlite *SQLite) FinishRenderJob(status model.RenderJobStatus, failure string, ID int64) error {
+	jobError := sql.NullString{String: failure, Valid: len(failure) > 0}
+
+	_, err := sqlite.db.Exec("UPDATE render_job SET status = ?, error = ?, finished_time = ? WHERE id = ?", status, jobError, time.Now().UTC(), ID)
+
+	return err
+}
+
+// RequeueRunningRenderJobs puts the jobs that were interrupted by a restart
+// back into the queue.
+func (sqlite *SQLite) RequeueRunningRenderJobs() error {
+	_, err := sqlite.db.Exec("UPDATE render_job SET status = ? WHERE status = ?", model.RenderJobStatusQueued, model.RenderJobStatusRunning)
+
+	return err
+}
+
+// GetRenderJobs returns the running jobs, the queued ones in the order they
+// will be started and then the finished ones, newest first.
+func (sqlite *SQLite) GetRenderJobs() ([]model.RenderJob, error) {
+	queries := []string{
+		"SELECT " + renderJobColumns + " FROM render_job WHERE status = ? ORDER BY started_time, id",
+		"SELECT " + renderJobColumns + " FROM render_job WHERE status = ? ORDER BY run_after, id",
+		"SELECT " + renderJobColumns + " FROM render_job WHERE status IN (?, ?) ORDER BY finished_time DESC, id DESC",
+	}
+	args := [][]interface{}{
+		{model.RenderJobStatusRunning},
+		{model.RenderJobStatusQueued},
+		{model.RenderJobStatusDone, model.RenderJobStatusFailed},
+	}
+
+	jobs := []model.RenderJob{}
+	position := 0
+
+	for index, query := range queries {
+		rows, err := sqlite.db.Query(query, args[index]...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			job, err := scanRenderJob(rows)
+			if err != nil {
+				log.Println("Render job scan failed. Reason:", err)
+				continue
+			}
+
+			if job.Status == model.RenderJobStatusQueued {
+				position++
+				job.Position = position
+			}
+
+			jobs = append(jobs, *job)
+		}
+		rows.Close()
+	}
+
+	return jobs, nil
+}
+
+func (sqlite *SQLite) GetFeeds() ([]model.Feed, error) {
+	rows, err := sqlite.db.Query("SELECT " + feedColumns + " FROM feed")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
+
+	feeds := []model.Feed{}
+
+	for rows.Next() {
+		feed, err := scanFeed(rows)
+		if err != nil {
+			log.Println("Feed scan failed. Reason:", err)
+			continue
+		}
+
+		feeds = append(feeds, *feed)
+	}
+
+	for i := range feeds {
+		feeds[i].Rules, err = sqlite.getRulesForFeedID(feeds[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return feeds, nil
+}
+
+func (sqlite *SQLite) FeedWithID(ID int64) (*model.Feed, error) {
+	row := sqlite.db.QueryRow("SELECT "+feedColumns+" FROM feed WHERE id = ?", ID)
+
+	feed, err := scanFeed(row)
+	if err != nil {
+		return nil, err
+	}
+
+	feed.Rules, err = sqlite.getRulesForFeedID(feed.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return feed, nil
+}
+
+func (sqlite *SQLite) SaveFeed(feed *model.Feed) error {
+	result, err := sqlite.db.Exec("INSERT INTO feed(name, url, enabled) VALUES (?, ?, ?)", feed.Name, feed.URL, feed.Enabled)
+	if err != nil {
+		return err
+	}
+
+	feed.ID, err = result.LastInsertId()
+
+	return err
+}
+
+func (sqlite *SQLite) UpdateFeed(feed *model.Feed) error {
+	_, err := sqlite.db.Exec("UPDATE feed SET name = ?, url = ?, enabled = ? WHERE id = ?", feed.Name, feed.URL, feed.Enabled, feed.ID)
+
+	return err
+}
+
+func (sqlite *SQLite) DeleteFeed(ID int64) error {
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{"DELETE FROM feed_rule WHERE feed_id = ?", "DELETE FROM feed_item WHERE feed_id = ?", "DELETE FROM feed WHERE id = ?"} {
+		_, err = tx.Exec(query, ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetCheckedForFeed stores the time of the last check and its error, an empty
+// error clears the previous one.
+func (sqlite *SQLite) SetCheckedForFeed(checked time.Time, failure string, ID int64) error {
+	lastError := sql.NullString{String: failure, Valid: len(failure) > 0}
+
+	_, err := sqlite.db.Exec("UPDATE feed SET last_checked = ?, last_error = ? WHERE id = ?", checked, lastError, ID)
+
+	return err
+}
+
+func (sqlite *SQLite) SaveFeedRule(rule *model.FeedRule) error {
+	result, err := sqlite.db.Exec("INSERT INTO feed_rule(feed_id, name, include, exclude, quality, min_size, max_size) VALUES (?, ?, ?, ?, ?, ?, ?)", rule.FeedID, rule.Name, rule.Include, rule.Exclude, rule.Quality, rule.MinSize, rule.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	rule.ID, err = result.LastInsertId()
+
+	return err
+}
+
+func (sqlite *SQLite) UpdateFeedRule(rule *model.FeedRule) error {
+	result, err := sqlite.db.Exec("UPDATE feed_rule SET name = ?, include = ?, exclude = ?, quality = ?, min_size = ?, max_size = ? WHERE id = ? AND feed_id = ?", rule.Name, rule.Include, rule.Exclude, rule.Quality, rule.MinSize, rule.MaxSize, rule.ID, rule.FeedID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (sqlite *SQLite) DeleteFeedRule(ID int64, feedID int64) error {
+	result, err := sqlite.db.Exec("DELETE FROM feed_rule WHERE id = ? AND feed_id = ?", ID, feedID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// IsFeedItemAdded reports whether the item was already added from the feed,
+// even if its torrent was deleted since.
+func (sqlite *SQLite) IsFeedItemAdded(feedID int64, guid string) bool {
+	count := 0
+
+	row := sqlite.db.QueryRow("SELECT COUNT(*) FROM feed_item WHERE feed_id = ? AND guid = ?", feedID, guid)
+	row.Scan(&count)
+
+	return count > 0
+}
+
+func (sqlite *SQLite) SaveFeedItem(feedID int64, guid string, hash string) error {
+	_, err := sqlite.db.Exec("INSERT OR IGNORE INTO feed_item(feed_id, guid, hash, added_time) VALUES (?, ?, ?, ?)", feedID, guid, hash, time.Now())
+
+	return err
+}
+
+func (sqlite *SQLite) getRulesForFeedID(ID int64) ([]model.FeedRule, error) {
+	rows, err := sqlite.db.Query("SELECT "+feedRuleColumns+" FROM feed_rule WHERE feed_id = ?", ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []model.FeedRule{}
+
+	for rows.Next() {
+		var rule model.FeedRule
+
+		err := rows.Scan(&rule.ID, &rule.FeedID, &rule.Name, &rule.Include, &rule.Exclude, &rule.Quality, &rule.MinSize, &rule.MaxSize)
+		if err != nil {
+			log.Println("Feed rule scan failed. Reason:", err)
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (sqlite *SQLite) setFailureForTorrent(status model.TorrentStatus, reason string, ID string) error {
+	return sqlite.changeTorrentStatus(status, reason, ID, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE torrent SET status = ?, failure = ? WHERE id = ?", status, reason, ID)
+
+		return err
+	})
+}
+
+// changeTorrentStatus runs the update of the torrent status and records it as
+// an event in the same transaction. Setting the status a torrent already has
+// isn't recorded unless it comes with a message.
+func (sqlite *SQLite) changeTorrentStatus(status model.TorrentStatus, message string, ID string, update func(tx *sql.Tx) error) error {
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous model.TorrentStatus
+
+	err = tx.QueryRow("SELECT status FROM torrent WHERE id = ?", ID).Scan(&previous)
+	if err != nil {
+		return err
+	}
+
+	err = update(tx)
+	if err != nil {
+		return err
+	}
+
+	if previous != status || len(message) > 0 {
+		eventMessage := sql.NullString{String: message, Valid: len(message) > 0}
+
+		_, err = tx.Exec("INSERT INTO torrent_event(torrent_id, status, time, message) VALUES (?, ?, ?, ?)", ID, status, time.Now(), eventMessage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (sqlite *SQLite) getTorrentWithStatus(status model.TorrentStatus) ([]model.Torrent, error) {
+	rows, err := sqlite.db.Query("SELECT "+torrentColumns+" FROM torrent WHERE status = ?", status)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlite.scanTorrents(rows)
+}
+
+func (sqlite *SQLite) scanTorrents(rows *sql.Rows) ([]model.Torrent, error) {
+	torrents := []model.Torrent{}
+
+	for rows.Next() {
+		torrent, err := scanTorrent(rows)
+		if err != nil {
+			log.Println("Torrent scan failed. Reason:", err)
+			continue
+		}
+
+		torrent.Files, err = sqlite.getFilesForTorrentID(torrent.ID)
+		if err != nil {
+			continue
+		}
+
+		torrents = append(torrents, *torrent)
+	}
+
+	return torrents, nil
+}
+
+func (sqlite *SQLite) saveTorrentFiles(files []model.File) {
+	for _, file := range files {
+		sqlite.db.Exec("INSERT INTO file(path, length, selected, torrent_id, subtitle) VALUES (?, ?, ?, ?, ?)", file.Path, file.Length, file.Selected, file.TorrentID, file.Subtitle)
+	}
+}
+
+func (sqlite *SQLite) deleteTorrentFiles(ID string) {
+	sqlite.db.Exec("DELETE FROM media_stream WHERE file_id IN (SELECT id FROM file WHERE torrent_id = ?)", ID)
+	sqlite.db.Exec("DELETE FROM media_info WHERE file_id IN (SELECT id FROM file WHERE torrent_id = ?)", ID)
+	sqlite.db.Exec("DELETE FROM file WHERE torrent_id = ?", ID)
+}
+
+func (sqlite *SQLite) deleteMediaInfo(fileID int64) {
+	sqlite.db.Exec("DELETE FROM media_stream WHERE file_id = ?", fileID)
+	sqlite.db.Exec("DELETE FROM media_info WHERE file_id = ?", fileID)
+}
+
+func (sqlite *SQLite) getFilesForTorrentID(ID string) ([]model.File, error) {
+	rows, err := sqlite.db.Query("SELECT id, path, length, selected, subtitle, torrent_id FROM file WHERE torrent_id = ?", ID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []model.File{}
+
+	for rows.Next() {
+		var file model.File
+
+		err := rows.Scan(&file.ID, &file.Path, &file.Length, &file.Selected, &file.Subtitle, &file.TorrentID)
+		if err != nil {
+			log.Println("File scan failed. Reason:", err)
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// Helper functions
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTorrent(row scanner) (*model.Torrent, error) {
+	torrent := model.Torrent{}
+
+	err := row.Scan(&torrent.ID, &torrent.Hash, &torrent.Name, &torrent.Magnet, &torrent.Status, &torrent.AddedTime, &torrent.Poster, &torrent.Metainfo, &torrent.Failure, &torrent.SelectFiles, &torrent.DownloadLimit, &torrent.Seeding, &torrent.SeedingTime, &torrent.Uploaded, &torrent.QueuePosition, &torrent.Resolutions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &torrent, nil
+}
+
+func scanRenderJob(row scanner) (*model.RenderJob, error) {
+	job := model.RenderJob{}
+
+	err := row.Scan(&job.ID, &job.TorrentID, &job.Status, &job.Attempts, &job.Error, &job.CreatedTime, &job.RunAfter, &job.StartedTime, &job.FinishedTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func scanFeed(row scanner) (*model.Feed, error) {
+	feed := model.Feed{}
+
+	err := row.Scan(&feed.ID, &feed.Name, &feed.URL, &feed.Enabled, &feed.LastChecked, &feed.LastError)
+	if err != nil {
+		return nil, err
+	}
+
+	return &feed, nil
+}
+
+func checkRowsAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (sqlite *SQLite) migrate() error {
+	version := sqlite.dbVersion()
+
+	switch version {
+	case 0:
+		err := migrateToVersion1(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 1:
+		err := migrateToVersion2(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 2:
+		err := migrateToVersion3(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 3:
+		err := migrateToVersion4(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 4:
+		err := migrateToVersion5(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 5:
+		err := migrateToVersion6(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 6:
+		err := migrateToVersion7(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 7:
+		err := migrateToVersion8(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 8:
+		err := migrateToVersion9(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 9:
+		err := migrateToVersion10(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 10:
+		err := migrateToVersion11(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 11:
+		err := migrateToVersion12(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 12:
+		err := migrateToVersion13(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 13:
+		err := migrateToVersion14(sqlite.db)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		log.Println("Database is fully migrated.")
+	}
+
+	sqlite.setDBVersion(CURRENT_DB_VERSION)
+
+	return nil
+}
+
+func (sqlite *SQLite) dbVersion() int {
+	var userVersion int = 0
+	rows, err := sqlite.db.Query("PRAGMA user_version")
+	if err != nil {
+		return userVersion
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		rows.Scan(&userVersion)
+	}
+
+	return userVersion
+}
+
+func (sqlite *SQLite) setDBVersion(version int) {
+	_, err := sqlite.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	if err != nil {
+		log.Println("Error setting database user version. Error:", err)
+	}
+}
+
+// Individual migrations
+
+func migrateToVersion1(db *sql.DB) error {
+	err := createTorrent(db)
+	if err != nil {
+		return err
+	}
+
+	err = createFiles(db)
+
+	return err
+}
+
+func migrateToVersion2(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN metainfo BLOB")
+
+	return err
+}
+
+func migrateToVersion3(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN failure TEXT")
+
+	return err
+}
+
+func migrateToVersion4(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN select_files INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// Before file selection only the files that were downloaded were stored.
+	_, err = db.Exec("ALTER TABLE file ADD COLUMN length INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE file ADD COLUMN selected INTEGER NOT NULL DEFAULT 1")
+
+	return err
+}
+
+func migrateToVersion5(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN download_limit INTEGER NOT NULL DEFAULT 0")
+
+	return err
+}
+
+func migrateToVersion6(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN seeding INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE torrent ADD COLUMN seeding_time INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE torrent ADD COLUMN uploaded INTEGER NOT NULL DEFAULT 0")
+
+	return err
+}
+
+func migrateToVersion7(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN queue_position INTEGER NOT NULL DEFAULT 0")
+
+	return err
+}
+
+func migrateToVersion8(db *sql.DB) error {
+	err := createFeeds(db)
+	if err != nil {
+		return err
+	}
+
+	err = createFeedRules(db)
+	if err != nil {
+		return err
+	}
+
+	err = createFeedItems(db)
+
+	return err
+}
+
+func migrateToVersion9(db *sql.DB) error {
+	err := createTorrentEvents(db)
+	if err != nil {
+		return err
+	}
+
+	// Torrents added before the history existed start it with their current
+	// status.
+	_, err = db.Exec("INSERT INTO torrent_event(torrent_id, status, time, message) SELECT id, status, added_time, failure FROM torrent")
+
+	return err
+}
+
+func migrateToVersion10(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE torrent ADD COLUMN resolutions TEXT NOT NULL DEFAULT ''")
+
+	return err
+}
+
+func migrateToVersion11(db *sql.DB) error {
+	err := createRenderJobs(db)
+	if err != nil {
+		return err
+	}
+
+	// Renders that were in progress are queued in the order the torrents
+	// were added.
+	now := time.Now().UTC()
+	_, err = db.Exec("INSERT INTO render_job(torrent_id, status, created_time, run_after) SELECT id, ?, ?, ? FROM torrent WHERE status = ? ORDER BY added_time", model.RenderJobStatusQueued, now, now, model.TorrentStatusRendering)
+
+	return err
+}
+
+func migrateToVersion12(db *sql.DB) error {
+	err := createMediaInfo(db)
+	if err != nil {
+		return err
+	}
+
+	err = createMediaStreams(db)
+
+	return err
+}
+
+func migrateToVersion13(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE media_info ADD COLUMN bit_rate INTEGER NOT NULL DEFAULT 0")
+
+	return err
+}
+
+func migrateToVersion14(db *sql.DB) error {
+	// Torrents that were added more than once keep only their first entry,
+	// the others shared its torrent in the client.
+	duplicates := "SELECT id FROM torrent t WHERE EXISTS (SELECT 1 FROM torrent o WHERE o.hash = t.hash AND o.rowid < t.rowid)"
+	duplicateFiles := "SELECT id FROM file WHERE torrent_id IN (" + duplicates + ")"
+
+	for _, query := range []string{
+		"DELETE FROM media_stream WHERE file_id IN (" + duplicateFiles + ")",
+		"DELETE FROM media_info WHERE file_id IN (" + duplicateFiles + ")",
+		"DELETE FROM file WHERE torrent_id IN (" + duplicates + ")",
+		"DELETE FROM torrent_event WHERE torrent_id IN (" + duplicates + ")",
+		"DELETE FROM render_job WHERE torrent_id IN (" + duplicates + ")",
+		"DELETE FROM torrent WHERE id IN (" + duplicates + ")",
+	} {
+		_, err := db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Exec("CREATE UNIQUE INDEX idx_torrent_hash ON torrent(hash)")
+
+	return err
+}
+
+// Table creation helpers
+
+func createTorrent(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE torrent (id TEXT PRIMARY KEY, hash TEXT, name TEXT, magnet TEXT, status INTEGER, added_time DATETIME, poster TEXT)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX idx_torrent_status ON torrent(status)")
+
+	return err
+}
+
+func createFiles(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE file (id INTEGER PRIMARY KEY, path TEXT, subtitle TEXT, torrent_id TEXT, FOREIGN KEY (torrent_id) REFERENCES torrent(id))")
+	if err != nil {
+		return err
+	}
+
+	return err
+}
+
+func createFeeds(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE feed (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT '', url TEXT NOT NULL, enabled INTEGER NOT NULL DEFAULT 1, last_checked DATETIME, last_error TEXT)")
+
+	return err
+}
+
+func createFeedRules(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE feed_rule (id INTEGER PRIMARY KEY, feed_id INTEGER NOT NULL, name TEXT NOT NULL DEFAULT '', include TEXT NOT NULL DEFAULT '', exclude TEXT NOT NULL DEFAULT '', quality TEXT NOT NULL DEFAULT '', min_size INTEGER NOT NULL DEFAULT 0, max_size INTEGER NOT NULL DEFAULT 0, FOREIGN KEY (feed_id) REFERENCES feed(id))")
+
+	return err
+}
+
+func createFeedItems(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE feed_item (feed_id INTEGER NOT NULL, guid TEXT NOT NULL, hash TEXT, added_time DATETIME, PRIMARY KEY (feed_id, guid), FOREIGN KEY (feed_id) REFERENCES feed(id))")
+
+	return err
+}
+
+func createTorrentEvents(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE torrent_event (id INTEGER PRIMARY KEY, torrent_id TEXT NOT NULL, status INTEGER NOT NULL, time DATETIME NOT NULL, message TEXT, FOREIGN KEY (torrent_id) REFERENCES torrent(id))")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX idx_torrent_event_torrent_id ON torrent_event(torrent_id)")
+
+	return err
+}
+
+func createRenderJobs(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE render_job (id INTEGER PRIMARY KEY, torrent_id TEXT NOT NULL, status INTEGER NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, error TEXT, created_time DATETIME NOT NULL, run_after DATETIME NOT NULL, started_time DATETIME, finished_time DATETIME, FOREIGN KEY (torrent_id) REFERENCES torrent(id))")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX idx_render_job_status ON render_job(status)")
+
+	return err
+}
+
+func createMediaInfo(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE media_info (file_id INTEGER PRIMARY KEY, duration REAL NOT NULL DEFAULT 0, container TEXT NOT NULL DEFAULT '', video_index INTEGER NOT NULL DEFAULT 0, video_codec TEXT NOT NULL DEFAULT '', width INTEGER NOT NULL DEFAULT 0, height INTEGER NOT NULL DEFAULT 0, frame_rate REAL NOT NULL DEFAULT 0, pixel_format TEXT NOT NULL DEFAULT '', hdr TEXT NOT NULL DEFAULT '', FOREIGN KEY (file_id) REFERENCES file(id))")
+
+	return err
+}
+
+func createMediaStreams(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE media_stream (id INTEGER PRIMARY KEY, file_id INTEGER NOT NULL, stream_index INTEGER NOT NULL, type TEXT NOT NULL, codec TEXT NOT NULL DEFAULT '', language TEXT NOT NULL DEFAULT '', title TEXT NOT NULL DEFAULT '', channels INTEGER NOT NULL DEFAULT 0, is_default INTEGER NOT NULL DEFAULT 0, FOREIGN KEY (file_id) REFERENCES file(id))")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX idx_media_stream_file_id ON media_stream(file_id)")
 
 	return err
 }
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"piflix/internal/model"
+	"testing"
+	"time"
+)
+
+func TestSaveTorrentRejectsDuplicateHash(t *testing.T) {
+	database := NewSQLiteDatabase(t.TempDir())
+	if database == nil {
+		t.Fatal("couldn't create database")
+	}
+
+	hash := "0000000000000000000000000000000000000001"
+
+	err := database.SaveTorrent(&model.Torrent{ID: "first", Hash: hash, AddedTime: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.SaveTorrent(&model.Torrent{ID: "second", Hash: hash, AddedTime: time.Now()})
+	if err == nil {
+		t.Error("a second torrent with the same hash was saved")
+	}
+}
+
+func TestMigrationRemovesDuplicateTorrents(t *testing.T) {
+	workDir := t.TempDir()
+
+	database := NewSQLiteDatabase(workDir)
+	if database == nil {
+		t.Fatal("couldn't create database")
+	}
+
+	// Go back to a database from before the unique index.
+	_, err := database.db.Exec("DROP INDEX idx_torrent_hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.setDBVersion(13)
+
+	hash := "0000000000000000000000000000000000000001"
+
+	for _, id := range []string{"first", "second", "third"} {
+		err = database.SaveTorrent(&model.Torrent{ID: id, Hash: hash, AddedTime: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		database.saveTorrentFiles([]model.File{{TorrentID: id, Path: id + ".mkv", Selected: true}})
+	}
+
+	database.db.Close()
+
+	database = NewSQLiteDatabase(workDir)
+	if database == nil {
+		t.Fatal("migration failed")
+	}
+
+	torrent, err := database.TorrentWithHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if torrent.ID != "first" || len(torrent.Files) != 1 {
+		t.Errorf("kept torrent %s with %d files, expected the first one with 1 file", torrent.ID, len(torrent.Files))
+	}
+
+	for _, id := range []string{"second", "third"} {
+		if _, err := database.TorrentWithID(id); err == nil {
+			t.Errorf("duplicate torrent %s wasn't removed", id)
+		}
+
+		if files, _ := database.getFilesForTorrentID(id); len(files) != 0 {
+			t.Errorf("files of duplicate torrent %s weren't removed", id)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"piflix/internal/model"
+	"piflix/internal/utility"
+	"strings"
+	"sync"
+)
+
+const mebibyte = 1024 * 1024
+
+// renderSizeRatio is the estimated size of a single rendered resolution
+// relative to the size of its source.
+const renderSizeRatio = 0.5
+
+// diskSpaceHysteresis is the space that has to be freed above the minimum
+// before the paused downloads and renders continue.
+const diskSpaceHysteresis = 256 * mebibyte
+
+// estimatedRenderSize is an upper bound because the source isn't probed yet.
+// It counts every resolution and a remuxed source variant, which is as big as
+// the source. The variant only replaces a preset of the same size, so both
+// are counted.
+func estimatedRenderSize(size int64, resolutions string) int64 {
+	count := len(strings.Split(resolutions, ","))
+
+	return size + int64(float64(size)*renderSizeRatio*float64(count))
+}
+
+// diskMonitor pauses the downloads and renders while the free space in the
+// working directory is below the configured minimum.
+type diskMonitor struct {
+	mutex          sync.Mutex
+	config         *Config
+	torrentManager *TorrentManager
+	hlsManager     *HLSManager
+	status         model.DiskSpace
+}
+
+func newDiskMonitor(config *Config, torrentManager *TorrentManager, hlsManager *HLSManager) *diskMonitor {
+	return &diskMonitor{
+		config:         config,
+		torrentManager: torrentManager,
+		hlsManager:     hlsManager,
+	}
+}
+
+func (dm *diskMonitor) check() {
+	free, err := utility.FreeSpace(dm.config.WorkDir)
+	if err != nil {
+		log.Println("Couldn't check free disk space:", err)
+		return
+	}
+
+	minFree := int64(dm.config.MinFreeSpace) * mebibyte
+
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	dm.status.Free = free
+	dm.status.MinFree = minFree
+
+	low := free < minFree || (dm.status.Low && free < minFree+diskSpaceHysteresis)
+	if low == dm.status.Low {
+		return
+	}
+
+	dm.status.Low = low
+	if low {
+		dm.status.Error = fmt.Sprintf("downloads and renders are paused, only %d MiB of disk space is free", free/mebibyte)
+		log.Println("Low disk space,", dm.status.Error)
+	} else {
+		dm.status.Error = ""
+		log.Println("Disk space freed, continuing downloads and renders.")
+	}
+
+	dm.torrentManager.SetDiskSpaceLow(low)
+	dm.hlsManager.SetSuspended(low)
+}
+
+func (dm *diskMonitor) Status() model.DiskSpace {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return dm.status
+}
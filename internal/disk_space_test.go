@@ -0,0 +1,20 @@
+package internal
+
+import "testing"
+
+func TestEstimatedRenderSize(t *testing.T) {
+	tests := []struct {
+		resolutions string
+		expected    int64
+	}{
+		// The remuxed source and half of it for the preset.
+		{"720p", 1500 * mebibyte},
+		{"1080p,720p,480p", 2500 * mebibyte},
+	}
+
+	for _, test := range tests {
+		if size := estimatedRenderSize(1000*mebibyte, test.resolutions); size != test.expected {
+			t.Errorf("estimated size for %s is %d MiB, expected %d MiB", test.resolutions, size/mebibyte, test.expected/mebibyte)
+		}
+	}
+}
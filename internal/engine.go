@@ -2,10 +2,12 @@ package internal
 
 import (
 	"embed"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"piflix/internal/db"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron/v3"
@@ -17,6 +19,9 @@ type Engine struct {
 	database       *db.SQLite
 	torrentManager *TorrentManager
 	hlsManager     *HLSManager
+	diskMonitor    *diskMonitor
+	watchFolder    *watchFolder
+	feedManager    *FeedManager
 	cron           *cron.Cron
 }
 
@@ -29,6 +34,9 @@ func NewEngine(config *Config, webFS *embed.FS) *Engine {
 	engine.torrentManager = NewTorrentManager(engine.config)
 	engine.torrentManager.database = engine.database
 	engine.hlsManager = NewHLSManager(engine.database, engine.config)
+	engine.torrentManager.renderQueue = engine.hlsManager.RenderQueueChan
+	engine.feedManager = NewFeedManager(engine.database, engine.torrentManager)
+	engine.diskMonitor = newDiskMonitor(engine.config, engine.torrentManager, engine.hlsManager)
 
 	if !engine.checkDependencies() {
 		log.Fatalln("Can't start piflix. Running requirements not satisfied. Aborting.")
@@ -40,14 +48,32 @@ func NewEngine(config *Config, webFS *embed.FS) *Engine {
 }
 
 func (e *Engine) Run() {
+	e.diskMonitor.check()
+
 	e.restartDownloads()
+	e.restartSeeding()
 	e.restartRenders()
 
+	e.startWatchFolder()
+
 	e.setupCron()
 
 	e.router.Run(":4000")
 }
 
+func (e *Engine) startWatchFolder() {
+	if len(e.config.WatchDir) == 0 {
+		return
+	}
+
+	e.watchFolder = newWatchFolder(e.config.WatchDir, e.torrentManager)
+
+	err := e.watchFolder.start()
+	if err != nil {
+		log.Println("Couldn't watch folder", e.config.WatchDir, "Error:", err)
+	}
+}
+
 func (e *Engine) LogInfo() {
 	e.torrentManager.printStatus()
 }
@@ -55,11 +81,37 @@ func (e *Engine) LogInfo() {
 func (e *Engine) setupCron() {
 	e.cron = cron.New()
 
-	e.cron.AddFunc("@every 3s", e.checkStatus)
+	e.cron.AddFunc("@every 3s", e.LogInfo)
+	e.cron.AddFunc("@every 1s", e.torrentManager.throttleTorrents)
+	e.cron.AddFunc("@every 1s", e.torrentManager.sampleTransferRates)
+	e.cron.AddFunc("@every 1m", e.torrentManager.checkSeedingTorrents)
+	e.cron.AddFunc("@every 10s", e.diskMonitor.check)
+	e.cron.AddFunc(fmt.Sprintf("@every %s", e.config.FeedInterval), e.feedManager.checkFeeds)
+
+	for _, window := range e.config.BandwidthSchedule {
+		specs, _ := window.cronSpecs()
+		for _, spec := range specs {
+			e.cron.AddFunc(spec, e.applyBandwidthSchedule)
+		}
+	}
+
+	e.applyBandwidthSchedule()
 
 	e.cron.Start()
 }
 
+func (e *Engine) applyBandwidthSchedule() {
+	downloadLimit, uploadLimit := scheduledBandwidthLimits(e.config, time.Now())
+
+	current := e.torrentManager.BandwidthLimits()
+	if current.DownloadLimit == downloadLimit && current.UploadLimit == uploadLimit {
+		return
+	}
+
+	log.Printf("Changing bandwidth limits to %d KiB/s download and %d KiB/s upload.", downloadLimit, uploadLimit)
+	e.torrentManager.SetBandwidthLimits(downloadLimit, uploadLimit)
+}
+
 func (e *Engine) restartDownloads() {
 	torrents, err := e.database.GetDownloadingTorrents()
 	if err != nil {
@@ -67,9 +119,50 @@ func (e *Engine) restartDownloads() {
 		return
 	}
 
+	pausedTorrents, err := e.database.GetPausedTorrents()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	queuedTorrents, err := e.database.GetQueuedTorrents()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	selectingTorrents, err := e.database.GetSelectingFilesTorrents()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	fetchingTorrents, err := e.database.GetFetchingMetadataTorrents()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	torrents = append(torrents, pausedTorrents...)
+	torrents = append(torrents, queuedTorrents...)
+	torrents = append(torrents, selectingTorrents...)
+	torrents = append(torrents, fetchingTorrents...)
+
 	e.torrentManager.resumeTorrents(torrents)
 }
 
+func (e *Engine) restartSeeding() {
+	torrents, err := e.database.GetSeedingTorrents()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	e.torrentManager.resumeSeeding(torrents)
+}
+
+// restartRenders queues the rendering torrents that have no render job, the
+// jobs themselves are kept across restarts.
 func (e *Engine) restartRenders() {
 	torrents, err := e.database.GetRenderingTorrents()
 	if err != nil {
@@ -82,12 +175,6 @@ func (e *Engine) restartRenders() {
 	}
 }
 
-func (e *Engine) checkStatus() {
-	e.LogInfo()
-
-	e.torrentManager.checkForCompletedTorrents(e.hlsManager.RenderQueueChan)
-}
-
 func (e *Engine) checkDependencies() bool {
 	return e.checkDirectories() && e.hlsManager.checkDependencies()
 }
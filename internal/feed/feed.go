@@ -0,0 +1,227 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Item is a torrent published in a RSS or Atom feed.
+type Item struct {
+	GUID  string
+	Title string
+
+	// Link is either a magnet link or the URL of a .torrent file.
+	Link string
+
+	// Size in bytes, zero if the feed doesn't publish it.
+	Size int64
+}
+
+// IsMagnet reports whether the item links to a magnet instead of a .torrent
+// file.
+func (i *Item) IsMagnet() bool {
+	return strings.HasPrefix(i.Link, "magnet:")
+}
+
+type rss struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	GUID      string `xml:"guid"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"enclosure"`
+
+	// Elements of the torrent namespace used by ezRSS and similar feeds.
+	MagnetURI     string `xml:"magnetURI"`
+	ContentLength string `xml:"contentLength"`
+
+	// Attributes used by Torznab feeds.
+	Attributes []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+}
+
+type atom struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"link"`
+}
+
+// Parse returns the items of a RSS 2.0 or an Atom feed.
+func Parse(data []byte) ([]Item, error) {
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root {
+	case "rss":
+		return parseRSS(data)
+	case "feed":
+		return parseAtom(data)
+	default:
+		return nil, errors.New("unsupported feed format " + root)
+	}
+}
+
+func parseRSS(data []byte) ([]Item, error) {
+	var feed rss
+
+	err := unmarshal(data, &feed)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []Item{}
+
+	for _, rssItem := range feed.Items {
+		item := Item{
+			GUID:  rssItem.GUID,
+			Title: strings.TrimSpace(rssItem.Title),
+			Size:  parseSize(rssItem.ContentLength, rssItem.Enclosure.Length),
+		}
+
+		for _, attribute := range rssItem.Attributes {
+			switch attribute.Name {
+			case "magneturl":
+				item.Link = attribute.Value
+			case "size":
+				item.Size = parseSize(attribute.Value)
+			}
+		}
+
+		item.Link = torrentLink(rssItem.MagnetURI, item.Link, rssItem.Link, rssItem.Enclosure.URL)
+		if item.Link == "" {
+			continue
+		}
+
+		if item.GUID == "" {
+			item.GUID = item.Link
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func parseAtom(data []byte) ([]Item, error) {
+	var feed atom
+
+	err := unmarshal(data, &feed)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []Item{}
+
+	for _, entry := range feed.Entries {
+		item := Item{
+			GUID:  entry.ID,
+			Title: strings.TrimSpace(entry.Title),
+		}
+
+		var enclosure, alternate string
+		for _, link := range entry.Links {
+			switch link.Rel {
+			case "enclosure":
+				enclosure = link.Href
+				item.Size = parseSize(link.Length)
+			case "", "alternate":
+				alternate = link.Href
+			}
+		}
+
+		item.Link = torrentLink(enclosure, alternate)
+		if item.Link == "" {
+			continue
+		}
+
+		if item.GUID == "" {
+			item.GUID = item.Link
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// torrentLink prefers a magnet link and otherwise returns the first of the
+// links that is set.
+func torrentLink(links ...string) string {
+	for _, link := range links {
+		if strings.HasPrefix(strings.TrimSpace(link), "magnet:") {
+			return strings.TrimSpace(link)
+		}
+	}
+
+	for _, link := range links {
+		if strings.TrimSpace(link) != "" {
+			return strings.TrimSpace(link)
+		}
+	}
+
+	return ""
+}
+
+func parseSize(values ...string) int64 {
+	for _, value := range values {
+		size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err == nil && size > 0 {
+			return size
+		}
+	}
+
+	return 0
+}
+
+func rootElement(data []byte) (string, error) {
+	decoder := newDecoder(data)
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+
+		if element, ok := token.(xml.StartElement); ok {
+			return element.Name.Local, nil
+		}
+	}
+}
+
+func unmarshal(data []byte, v interface{}) error {
+	return newDecoder(data).Decode(v)
+}
+
+func newDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	// Feeds that declare another charset are almost always plain ASCII in the
+	// parts that are used.
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	return decoder
+}
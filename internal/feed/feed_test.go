@@ -0,0 +1,164 @@
+package feed
+
+import (
+	"piflix/internal/model"
+	"testing"
+)
+
+const rssFeed = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:torrent="http://xmlns.ezrss.it/0.1/" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+<channel>
+	<title>Shows</title>
+	<item>
+		<title> Show S01E01 1080p WEB </title>
+		<link>https://example.com/show-s01e01.torrent</link>
+		<guid>show-s01e01</guid>
+		<enclosure url="https://example.com/show-s01e01.torrent" length="1073741824" type="application/x-bittorrent"/>
+	</item>
+	<item>
+		<title>Show S01E02 720p</title>
+		<link>https://example.com/show-s01e02.torrent</link>
+		<torrent:magnetURI>magnet:?xt=urn:btih:0000000000000000000000000000000000000002</torrent:magnetURI>
+		<torrent:contentLength>524288000</torrent:contentLength>
+	</item>
+	<item>
+		<title>Show S01E03 2160p</title>
+		<guid>show-s01e03</guid>
+		<link>https://example.com/show-s01e03</link>
+		<torznab:attr name="magneturl" value="magnet:?xt=urn:btih:0000000000000000000000000000000000000003"/>
+		<torznab:attr name="size" value="2147483648"/>
+	</item>
+	<item>
+		<title>Without a link</title>
+		<guid>no-link</guid>
+	</item>
+</channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Movies</title>
+	<entry>
+		<id>urn:movie:1</id>
+		<title>Movie 2021 1080p</title>
+		<link href="https://example.com/movie"/>
+		<link rel="enclosure" href="magnet:?xt=urn:btih:0000000000000000000000000000000000000001" length="3221225472"/>
+	</entry>
+	<entry>
+		<title>Other Movie 720p</title>
+		<link rel="alternate" href="https://example.com/other-movie.torrent"/>
+	</entry>
+	<entry>
+		<id>urn:movie:3</id>
+		<title>Without a link</title>
+		<link rel="self" href="https://example.com/feed"/>
+	</entry>
+</feed>`
+
+func TestParseRSS(t *testing.T) {
+	items, err := Parse([]byte(rssFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Item{
+		{GUID: "show-s01e01", Title: "Show S01E01 1080p WEB", Link: "https://example.com/show-s01e01.torrent", Size: 1073741824},
+		{GUID: "magnet:?xt=urn:btih:0000000000000000000000000000000000000002", Title: "Show S01E02 720p", Link: "magnet:?xt=urn:btih:0000000000000000000000000000000000000002", Size: 524288000},
+		{GUID: "show-s01e03", Title: "Show S01E03 2160p", Link: "magnet:?xt=urn:btih:0000000000000000000000000000000000000003", Size: 2147483648},
+	}
+
+	checkItems(t, items, expected)
+
+	if items[0].IsMagnet() || !items[1].IsMagnet() {
+		t.Error("IsMagnet doesn't match the links")
+	}
+}
+
+func TestParseAtom(t *testing.T) {
+	items, err := Parse([]byte(atomFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Item{
+		{GUID: "urn:movie:1", Title: "Movie 2021 1080p", Link: "magnet:?xt=urn:btih:0000000000000000000000000000000000000001", Size: 3221225472},
+		{GUID: "https://example.com/other-movie.torrent", Title: "Other Movie 720p", Link: "https://example.com/other-movie.torrent"},
+	}
+
+	checkItems(t, items, expected)
+}
+
+func TestParseUnsupported(t *testing.T) {
+	_, err := Parse([]byte(`<html><body>Not a feed</body></html>`))
+	if err == nil {
+		t.Error("expected an error for an unsupported feed")
+	}
+
+	_, err = Parse([]byte(``))
+	if err == nil {
+		t.Error("expected an error for an empty feed")
+	}
+}
+
+func checkItems(t *testing.T, items []Item, expected []Item) {
+	t.Helper()
+
+	if len(items) != len(expected) {
+		t.Fatalf("got %d items, expected %d: %+v", len(items), len(expected), items)
+	}
+
+	for i := range expected {
+		if items[i] != expected[i] {
+			t.Errorf("item %d is %+v, expected %+v", i, items[i], expected[i])
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    model.FeedRule
+		item    Item
+		matches bool
+	}{
+		{"empty rule", model.FeedRule{}, Item{Title: "Anything"}, true},
+		{"include", model.FeedRule{Include: `show s\d+e\d+`}, Item{Title: "Show S01E01 1080p"}, true},
+		{"include is case insensitive", model.FeedRule{Include: "SHOW"}, Item{Title: "show s01e01"}, true},
+		{"include doesn't match", model.FeedRule{Include: "other"}, Item{Title: "Show S01E01 1080p"}, false},
+		{"exclude", model.FeedRule{Exclude: "cam|ts"}, Item{Title: "Movie CAM"}, false},
+		{"exclude doesn't match", model.FeedRule{Exclude: "cam"}, Item{Title: "Movie 1080p"}, true},
+		{"include and exclude", model.FeedRule{Include: "show", Exclude: "720p"}, Item{Title: "Show S01E02 720p"}, false},
+		{"quality", model.FeedRule{Quality: "1080p, 2160P"}, Item{Title: "Movie 2160p"}, true},
+		{"quality doesn't match", model.FeedRule{Quality: "1080p,2160p"}, Item{Title: "Movie 720p"}, false},
+		{"quality is ignored when empty", model.FeedRule{Quality: " , "}, Item{Title: "Movie 720p"}, true},
+		{"min size", model.FeedRule{MinSize: 500}, Item{Title: "Movie", Size: 100 * mebibyte}, false},
+		{"max size", model.FeedRule{MaxSize: 500}, Item{Title: "Movie", Size: 1000 * mebibyte}, false},
+		{"within size bounds", model.FeedRule{MinSize: 500, MaxSize: 1000}, Item{Title: "Movie", Size: 700 * mebibyte}, true},
+		{"unknown size", model.FeedRule{MinSize: 500, MaxSize: 1000}, Item{Title: "Movie"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := NewFilter(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if filter.Matches(test.item) != test.matches {
+				t.Errorf("Matches(%+v) with %+v is %t", test.item, test.rule, !test.matches)
+			}
+		})
+	}
+}
+
+func TestFilterInvalidRegexp(t *testing.T) {
+	_, err := NewFilter(model.FeedRule{Include: "("})
+	if err == nil {
+		t.Error("expected an error for an invalid include")
+	}
+
+	_, err = NewFilter(model.FeedRule{Exclude: "["})
+	if err == nil {
+		t.Error("expected an error for an invalid exclude")
+	}
+}
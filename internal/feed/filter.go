@@ -0,0 +1,89 @@
+package feed
+
+import (
+	"piflix/internal/model"
+	"regexp"
+	"strings"
+)
+
+const mebibyte = 1024 * 1024
+
+// Filter is a compiled feed rule.
+type Filter struct {
+	include   *regexp.Regexp
+	exclude   *regexp.Regexp
+	qualities []string
+	minSize   int64
+	maxSize   int64
+}
+
+func NewFilter(rule model.FeedRule) (*Filter, error) {
+	filter := &Filter{
+		minSize: rule.MinSize * mebibyte,
+		maxSize: rule.MaxSize * mebibyte,
+	}
+
+	var err error
+
+	if rule.Include != "" {
+		filter.include, err = regexp.Compile("(?i)" + rule.Include)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.Exclude != "" {
+		filter.exclude, err = regexp.Compile("(?i)" + rule.Exclude)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, quality := range strings.Split(rule.Quality, ",") {
+		quality = strings.ToLower(strings.TrimSpace(quality))
+		if quality != "" {
+			filter.qualities = append(filter.qualities, quality)
+		}
+	}
+
+	return filter, nil
+}
+
+// Matches reports whether the item passes all filters.
+func (f *Filter) Matches(item Item) bool {
+	if f.include != nil && !f.include.MatchString(item.Title) {
+		return false
+	}
+
+	if f.exclude != nil && f.exclude.MatchString(item.Title) {
+		return false
+	}
+
+	if len(f.qualities) > 0 && !f.matchesQuality(item.Title) {
+		return false
+	}
+
+	if item.Size > 0 {
+		if f.minSize > 0 && item.Size < f.minSize {
+			return false
+		}
+
+		if f.maxSize > 0 && item.Size > f.maxSize {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Filter) matchesQuality(title string) bool {
+	title = strings.ToLower(title)
+
+	for _, quality := range f.qualities {
+		if strings.Contains(title, quality) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"piflix/internal/db"
+	"piflix/internal/feed"
+	"piflix/internal/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FeedHandler struct {
+	feedManager *FeedManager
+	database    *db.SQLite
+}
+
+func (fh *FeedHandler) Feeds(c *gin.Context) {
+	feeds, err := fh.database.GetFeeds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"feeds":  feeds,
+	})
+}
+
+func (fh *FeedHandler) AddFeed(c *gin.Context) {
+	var feedRequest model.FeedRequest
+
+	if err := c.ShouldBindJSON(&feedRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !isValidFeedURL(feedRequest.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid feed url"})
+		return
+	}
+
+	feed := &model.Feed{
+		Name:    feedRequest.Name,
+		URL:     feedRequest.URL,
+		Enabled: feedRequest.Enabled == nil || *feedRequest.Enabled,
+		Rules:   []model.FeedRule{},
+	}
+
+	err := fh.database.SaveFeed(feed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"feed":   feed,
+	})
+}
+
+func (fh *FeedHandler) UpdateFeed(c *gin.Context) {
+	feed, ok := fh.feedFromParam(c)
+	if !ok {
+		return
+	}
+
+	var feedRequest model.FeedRequest
+
+	if err := c.ShouldBindJSON(&feedRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !isValidFeedURL(feedRequest.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid feed url"})
+		return
+	}
+
+	feed.Name = feedRequest.Name
+	feed.URL = feedRequest.URL
+	if feedRequest.Enabled != nil {
+		feed.Enabled = *feedRequest.Enabled
+	}
+
+	err := fh.database.UpdateFeed(feed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"feed":   feed,
+	})
+}
+
+func (fh *FeedHandler) DeleteFeed(c *gin.Context) {
+	feed, ok := fh.feedFromParam(c)
+	if !ok {
+		return
+	}
+
+	err := fh.database.DeleteFeed(feed.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+// CheckFeed checks the feed right away instead of waiting for the next
+// scheduled check.
+func (fh *FeedHandler) CheckFeed(c *gin.Context) {
+	feed, ok := fh.feedFromParam(c)
+	if !ok {
+		return
+	}
+
+	err := fh.feedManager.CheckFeed(feed)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+func (fh *FeedHandler) AddFeedRule(c *gin.Context) {
+	feed, ok := fh.feedFromParam(c)
+	if !ok {
+		return
+	}
+
+	rule, ok := bindFeedRule(c)
+	if !ok {
+		return
+	}
+
+	rule.FeedID = feed.ID
+
+	err := fh.database.SaveFeedRule(rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"rule":   rule,
+	})
+}
+
+func (fh *FeedHandler) UpdateFeedRule(c *gin.Context) {
+	feed, ok := fh.feedFromParam(c)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseInt(c.Param("ruleid"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	rule, ok := bindFeedRule(c)
+	if !ok {
+		return
+	}
+
+	rule.ID = ruleID
+	rule.FeedID = feed.ID
+
+	err = fh.database.UpdateFeedRule(rule)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"rule":   rule,
+	})
+}
+
+func (fh *FeedHandler) DeleteFeedRule(c *gin.Context) {
+	feed, ok := fh.feedFromParam(c)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseInt(c.Param("ruleid"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	err = fh.database.DeleteFeedRule(ruleID, feed.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+// Helper functions
+
+func (fh *FeedHandler) feedFromParam(c *gin.Context) (*model.Feed, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+
+	feed, err := fh.database.FeedWithID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feed not found"})
+		return nil, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return feed, true
+}
+
+func bindFeedRule(c *gin.Context) (*model.FeedRule, bool) {
+	var rule model.FeedRule
+
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if rule.MinSize < 0 || rule.MaxSize < 0 || (rule.MaxSize > 0 && rule.MinSize > rule.MaxSize) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size bounds"})
+		return nil, false
+	}
+
+	if _, err := feed.NewFilter(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return &rule, true
+}
+
+func isValidFeedURL(feedURL string) bool {
+	parsed, err := url.Parse(feedURL)
+
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"piflix/internal/db"
+	"piflix/internal/feed"
+	"piflix/internal/model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const feedRequestTimeout = 30 * time.Second
+
+// maxFeedDownloadSize limits the size of the downloaded feeds and .torrent
+// files.
+const maxFeedDownloadSize = 10 * 1024 * 1024
+
+// FeedManager polls the subscribed feeds and adds the items that match their
+// rules.
+type FeedManager struct {
+	database       *db.SQLite
+	torrentManager *TorrentManager
+	client         *http.Client
+	mutex          sync.Mutex
+}
+
+func NewFeedManager(database *db.SQLite, torrentManager *TorrentManager) *FeedManager {
+	return &FeedManager{
+		database:       database,
+		torrentManager: torrentManager,
+		client:         &http.Client{Timeout: feedRequestTimeout},
+	}
+}
+
+func (fm *FeedManager) checkFeeds() {
+	feeds, err := fm.database.GetFeeds()
+	if err != nil {
+		log.Println("Couldn't load feeds. Error:", err)
+		return
+	}
+
+	for i := range feeds {
+		if !feeds[i].Enabled {
+			continue
+		}
+
+		err = fm.CheckFeed(&feeds[i])
+		if err != nil {
+			log.Println("Checking feed", feeds[i].URL, "failed. Error:", err)
+		}
+	}
+}
+
+// CheckFeed adds the new items of the feed that match any of its rules.
+func (fm *FeedManager) CheckFeed(f *model.Feed) error {
+	// Checks started by the cron and through the API mustn't add the same
+	// item twice.
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	err := fm.checkFeed(f)
+
+	failure := ""
+	if err != nil {
+		failure = err.Error()
+	}
+
+	fm.database.SetCheckedForFeed(time.Now(), failure, f.ID)
+
+	return err
+}
+
+func (fm *FeedManager) checkFeed(f *model.Feed) error {
+	data, err := fm.download(f.URL)
+	if err != nil {
+		return err
+	}
+
+	items, err := feed.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	filters := []*feed.Filter{}
+	for _, rule := range f.Rules {
+		filter, err := feed.NewFilter(rule)
+		if err != nil {
+			log.Println("Skipping invalid rule", rule.ID, "of feed", f.ID, "Error:", err)
+			continue
+		}
+
+		filters = append(filters, filter)
+	}
+
+	var errorMessages []string
+
+	for _, item := range items {
+		if !matchesAnyFilter(filters, item) || fm.database.IsFeedItemAdded(f.ID, item.GUID) {
+			continue
+		}
+
+		hash, err := fm.addItem(item)
+		if err != nil && !errors.Is(err, errTorrentAlreadyAdded) {
+			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", item.Title, err))
+			continue
+		}
+
+		if err == nil {
+			log.Println("Added", item.Title, "from feed", f.URL)
+		}
+
+		err = fm.database.SaveFeedItem(f.ID, item.GUID, hash)
+		if err != nil {
+			log.Println("Couldn't save feed item", item.GUID, "Error:", err)
+		}
+	}
+
+	if len(errorMessages) > 0 {
+		return errors.New(strings.Join(errorMessages, "\n"))
+	}
+
+	return nil
+}
+
+// addItem adds the torrent of the item and returns its info hash.
+func (fm *FeedManager) addItem(item feed.Item) (string, error) {
+	if item.IsMagnet() {
+		magnet, err := metainfo.ParseMagnetUri(item.Link)
+		if err != nil {
+			return "", errInvalidMagnet
+		}
+
+		_, err = fm.torrentManager.AddMagnet(item.Link, false)
+
+		return magnet.InfoHash.String(), err
+	}
+
+	data, err := fm.download(item.Link)
+	if err != nil {
+		return "", err
+	}
+
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return "", errInvalidTorrentFile
+	}
+
+	_, err = fm.torrentManager.AddTorrentFile(data, false)
+
+	return mi.HashInfoBytes().String(), err
+}
+
+func (fm *FeedManager) download(url string) ([]byte, error) {
+	resp, err := fm.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxFeedDownloadSize + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxFeedDownloadSize {
+		return nil, errors.New("response is too large")
+	}
+
+	return data, nil
+}
+
+func matchesAnyFilter(filters []*feed.Filter, item feed.Item) bool {
+	for _, filter := range filters {
+		if filter.Matches(item) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"piflix/internal/db"
+	"piflix/internal/feed"
+	"piflix/internal/model"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// newTestTorrentManager returns a torrent manager with a database in a
+// temporary directory whose client doesn't connect to anything.
+func newTestTorrentManager(t *testing.T) *TorrentManager {
+	t.Helper()
+
+	config := &Config{
+		WorkDir:         t.TempDir(),
+		MetadataTimeout: time.Hour,
+		Torrent: TorrentConfig{
+			Encryption: encryptionPreferred,
+		},
+	}
+
+	database := db.NewSQLiteDatabase(config.WorkDir)
+	if database == nil {
+		t.Fatal("couldn't create database")
+	}
+
+	torrentManager := NewTorrentManager(config)
+	if torrentManager == nil {
+		t.Fatal("couldn't create torrent manager")
+	}
+
+	torrentManager.database = database
+
+	// Removing the torrents first lets the goroutines that wait for their
+	// metadata return without touching the database.
+	t.Cleanup(func() {
+		torrentManager.mutex.Lock()
+		ids := []string{}
+		for _, torrents := range []map[string]*ActiveTorrent{torrentManager.activeTorrents, torrentManager.seedingTorrents} {
+			for id := range torrents {
+				ids = append(ids, id)
+			}
+		}
+		torrentManager.mutex.Unlock()
+
+		for _, id := range ids {
+			torrentManager.stopAndRemoveTorrentWithID(id)
+		}
+
+		torrentManager.Client.Close()
+	})
+
+	return torrentManager
+}
+
+// testTorrentFile returns a .torrent file with a single file that is big
+// enough to be downloaded by default.
+func testTorrentFile(t *testing.T, name string) ([]byte, metainfo.Hash) {
+	t.Helper()
+
+	info := metainfo.Info{
+		Name:        name,
+		PieceLength: 2 * fileSizeLimit,
+		Length:      2 * fileSizeLimit,
+		Pieces:      make([]byte, 20),
+	}
+
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes}
+
+	var data bytes.Buffer
+	err = mi.Write(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data.Bytes(), mi.HashInfoBytes()
+}
+
+func TestFeedManagerDeduplicatesItemsByInfoHash(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	movieFile, movieHash := testTorrentFile(t, "Movie 1080p.mkv")
+	otherHash := metainfo.NewHashFromHex("0000000000000000000000000000000000000002")
+	excludedHash := metainfo.NewHashFromHex("0000000000000000000000000000000000000003")
+
+	var feedRequests, torrentRequests int32
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The first two items are the same torrent, once as a .torrent file and
+	// once as a magnet link.
+	rss := fmt.Sprintf(`<?xml version="1.0"?>
+<rss version="2.0">
+<channel>
+	<item><title>Movie 1080p</title><guid>movie-file</guid><link>%s/movie.torrent</link></item>
+	<item><title>Movie 1080p REPACK</title><guid>movie-magnet</guid><link>magnet:?xt=urn:btih:%s</link></item>
+	<item><title>Other Movie 1080p</title><guid>other</guid><link>magnet:?xt=urn:btih:%s</link></item>
+	<item><title>Movie 1080p CAM</title><guid>excluded</guid><link>magnet:?xt=urn:btih:%s</link></item>
+</channel>
+</rss>`, server.URL, movieHash.HexString(), otherHash.HexString(), excludedHash.HexString())
+
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&feedRequests, 1)
+		w.Write([]byte(rss))
+	})
+	mux.HandleFunc("/movie.torrent", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&torrentRequests, 1)
+		w.Write(movieFile)
+	})
+
+	database := torrentManager.database
+
+	f := &model.Feed{Name: "Movies", URL: server.URL + "/feed.xml", Enabled: true}
+	err := database.SaveFeed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.SaveFeedRule(&model.FeedRule{FeedID: f.ID, Name: "1080p", Exclude: "cam", Quality: "1080p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedManager := NewFeedManager(database, torrentManager)
+
+	for i := 0; i < 2; i++ {
+		feedManager.checkFeeds()
+	}
+
+	if requests := atomic.LoadInt32(&feedRequests); requests != 2 {
+		t.Errorf("feed was requested %d times, expected 2", requests)
+	}
+
+	if requests := atomic.LoadInt32(&torrentRequests); requests != 1 {
+		t.Errorf(".torrent file was requested %d times, expected 1", requests)
+	}
+
+	for _, guid := range []string{"movie-file", "movie-magnet", "other"} {
+		if !database.IsFeedItemAdded(f.ID, guid) {
+			t.Errorf("item %s is not marked as added", guid)
+		}
+	}
+
+	if database.IsFeedItemAdded(f.ID, "excluded") {
+		t.Error("excluded item is marked as added")
+	}
+
+	torrentManager.mutex.Lock()
+	added := map[string]int{}
+	for _, at := range torrentManager.activeTorrents {
+		added[at.torrent.InfoHash().HexString()]++
+	}
+	torrentManager.mutex.Unlock()
+
+	expected := map[string]int{movieHash.HexString(): 1, otherHash.HexString(): 1}
+	if !reflect.DeepEqual(added, expected) {
+		t.Errorf("added torrents by info hash are %v, expected %v", added, expected)
+	}
+
+	checked, err := database.FeedWithID(f.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checked.LastChecked == nil || checked.LastError.Valid {
+		t.Errorf("feed check wasn't recorded as successful: %+v", checked)
+	}
+}
+
+func TestMatchesAnyFilter(t *testing.T) {
+	filters := []*feed.Filter{}
+	for _, rule := range []model.FeedRule{
+		{Include: "show", Quality: "1080p"},
+		{Include: "movie", Exclude: "cam", MaxSize: 4096},
+	} {
+		filter, err := feed.NewFilter(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters = append(filters, filter)
+	}
+
+	tests := []struct {
+		item    feed.Item
+		matches bool
+	}{
+		{feed.Item{Title: "Show S01E01 1080p"}, true},
+		{feed.Item{Title: "Show S01E01 720p"}, false},
+		{feed.Item{Title: "Movie 720p", Size: 1024 * mebibyte}, true},
+		{feed.Item{Title: "Movie CAM"}, false},
+		{feed.Item{Title: "Movie 2160p", Size: 8192 * mebibyte}, false},
+		{feed.Item{Title: "Documentary 1080p"}, false},
+	}
+
+	for _, test := range tests {
+		if matchesAnyFilter(filters, test.item) != test.matches {
+			t.Errorf("matchesAnyFilter(%+v) is %t", test.item, !test.matches)
+		}
+	}
+
+	if matchesAnyFilter(nil, feed.Item{Title: "Show S01E01 1080p"}) {
+		t.Error("an item matches a feed without rules")
+	}
+}
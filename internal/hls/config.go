@@ -1,6 +1,11 @@
 package hls
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"piflix/internal/model"
+)
 
 type config struct {
 	Name         string
@@ -9,6 +14,8 @@ type config struct {
 	BufSize      string
 	AudioBitrate string
 	Resolution   string
+	Width        int
+	Height       int
 	Bandwidth    string
 }
 
@@ -20,6 +27,8 @@ var preset = map[string]*config{
 		BufSize:      "1200k",
 		AudioBitrate: "96k",
 		Resolution:   "640x360",
+		Width:        640,
+		Height:       360,
 		Bandwidth:    "800000",
 	},
 	"480p": {
@@ -29,6 +38,8 @@ var preset = map[string]*config{
 		BufSize:      "2100k",
 		AudioBitrate: "128k",
 		Resolution:   "842x480",
+		Width:        842,
+		Height:       480,
 		Bandwidth:    "1400000",
 	},
 	"720p": {
@@ -38,6 +49,8 @@ var preset = map[string]*config{
 		BufSize:      "10600k",
 		AudioBitrate: "128k",
 		Resolution:   "1280x720",
+		Width:        1280,
+		Height:       720,
 		Bandwidth:    "5000000",
 	},
 	"1080p": {
@@ -47,10 +60,19 @@ var preset = map[string]*config{
 		BufSize:      "10600k",
 		AudioBitrate: "192k",
 		Resolution:   "1920x1080",
+		Width:        1920,
+		Height:       1080,
 		Bandwidth:    "5000000",
 	},
 }
 
+// HasPreset reports whether the resolution is one of the available presets.
+func HasPreset(res string) bool {
+	_, ok := preset[res]
+
+	return ok
+}
+
 func getConfig(res string) (*config, error) {
 	cfg, ok := preset[res]
 	if !ok {
@@ -59,3 +81,87 @@ func getConfig(res string) (*config, error) {
 
 	return cfg, nil
 }
+
+// SourceResolutions drops the resolutions that would only upscale the
+// source, those that are both wider and higher than it. If the source is
+// smaller than all of them only the source variant is rendered. When the
+// source can be remuxed the source variant comes first and only the
+// resolutions that are smaller than the source are transcoded.
+func SourceResolutions(resolutions []string, media *model.MediaInfo) []string {
+	if !hasVideoSize(media) {
+		return resolutions
+	}
+
+	remux := CanRemux(media)
+
+	kept := []string{}
+	if remux {
+		kept = append(kept, SourceVariant)
+	}
+
+	known := false
+
+	for _, res := range resolutions {
+		cfg, err := getConfig(res)
+		if err != nil {
+			continue
+		}
+
+		known = true
+
+		if cfg.Width > media.Video.Width && cfg.Height > media.Video.Height {
+			continue
+		}
+
+		if width, height := outputSize(cfg, media); remux && width >= media.Video.Width && height >= media.Video.Height {
+			continue
+		}
+
+		kept = append(kept, res)
+	}
+
+	if len(kept) == 0 && known {
+		kept = append(kept, SourceVariant)
+	}
+
+	return kept
+}
+
+// sourceConfig encodes a source that can't be remuxed at its own size with
+// the bit rates of the smallest preset, it is only used for sources that are
+// smaller than every preset.
+func sourceConfig(media *model.MediaInfo) *config {
+	cfg := *preset["360p"]
+	cfg.Name = SourceVariant
+	cfg.Width = media.Video.Width
+	cfg.Height = media.Video.Height
+	cfg.Resolution = fmt.Sprintf("%dx%d", cfg.Width, cfg.Height)
+
+	return &cfg
+}
+
+func hasVideoSize(media *model.MediaInfo) bool {
+	return media != nil && media.Video.Width > 0 && media.Video.Height > 0
+}
+
+// outputSize fits the source into the preset resolution keeping its aspect
+// ratio. Both sides are even because the encoder requires it.
+func outputSize(cfg *config, media *model.MediaInfo) (int, int) {
+	width := float64(media.Video.Width)
+	height := float64(media.Video.Height)
+	scale := math.Min(float64(cfg.Width)/width, float64(cfg.Height)/height)
+
+	return int(width*scale) / 2 * 2, int(height*scale) / 2 * 2
+}
+
+// scaleFilter scales the video to the preset resolution. Without the size of
+// the source ffmpeg fits it into the preset by itself.
+func scaleFilter(cfg *config, media *model.MediaInfo) string {
+	if !hasVideoSize(media) {
+		return fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2", cfg.Width, cfg.Height)
+	}
+
+	width, height := outputSize(cfg, media)
+
+	return fmt.Sprintf("scale=%d:%d", width, height)
+}
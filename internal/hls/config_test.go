@@ -0,0 +1,67 @@
+package hls
+
+import (
+	"piflix/internal/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func video(codec string, width, height int) *model.MediaInfo {
+	return &model.MediaInfo{Video: model.VideoStream{Codec: codec, Width: width, Height: height, PixelFormat: "yuv420p"}}
+}
+
+func TestSourceResolutions(t *testing.T) {
+	all := []string{"1080p", "720p", "480p", "360p"}
+
+	tests := []struct {
+		name        string
+		resolutions []string
+		media       *model.MediaInfo
+		expected    []string
+	}{
+		{"unknown size", all, nil, all},
+		{"upscaling is skipped", all, video("hevc", 1280, 720), []string{"720p", "480p", "360p"}},
+		{"wide source", all, video("hevc", 1280, 536), []string{"720p", "480p", "360p"}},
+		{"remux replaces the preset of the source size", all, video("h264", 1280, 720), []string{SourceVariant, "480p", "360p"}},
+		{"remux without a preset of the source size", []string{"720p", "480p"}, video("h264", 1920, 1080), []string{SourceVariant, "720p", "480p"}},
+		{"smaller than every preset", []string{"720p", "480p"}, video("hevc", 320, 240), []string{SourceVariant}},
+		{"remux smaller than every preset", []string{"720p", "480p"}, video("h264", 320, 240), []string{SourceVariant}},
+		{"only unknown presets", []string{"4k"}, video("hevc", 320, 240), []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolutions := SourceResolutions(test.resolutions, test.media)
+			if !reflect.DeepEqual(resolutions, test.expected) {
+				t.Errorf("resolutions are %v, expected %v", resolutions, test.expected)
+			}
+		})
+	}
+}
+
+func TestSmallSourceIsEncodedAtItsSize(t *testing.T) {
+	media := video("hevc", 320, 240)
+
+	options, err := getOptions("movie.mkv", "out", SourceVariant, false, media)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command := strings.Join(options, " ")
+	for _, option := range []string{"-vf scale=320:240", "-c:v h264", "out/source.m3u8"} {
+		if !strings.Contains(command, option) {
+			t.Errorf("options %q don't contain %q", command, option)
+		}
+	}
+
+	variants, err := GenerateHLSVariant([]string{SourceVariant}, "", media)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Variant{URL: "source.m3u8", Bandwidth: preset["360p"].Bandwidth, Resolution: "320x240"}
+	if len(variants) != 1 || *variants[0] != *expected {
+		t.Errorf("variants are %+v, expected %+v", variants, expected)
+	}
+}
@@ -1,25 +1,29 @@
 package hls
 
 import (
+	"context"
 	"log"
 	"os/exec"
+	"piflix/internal/model"
 )
 
 // GenerateHLS will generate HLS file based on resolution presets.
 // The available resolutions are: 360p, 480p, 720p and 1080p.
-func GenerateHLS(ffmpegPath, srcPath, targetPath, resolution string, ffmpegOnRPI bool) (*exec.Cmd, error) {
-	options, err := getOptions(srcPath, targetPath, resolution, ffmpegOnRPI)
+// The media info from Probe is optional. ffmpeg is killed when the context is
+// done.
+func GenerateHLS(ctx context.Context, ffmpegPath, srcPath, targetPath, resolution string, ffmpegOnRPI bool, media *model.MediaInfo) (*exec.Cmd, error) {
+	options, err := getOptions(srcPath, targetPath, resolution, ffmpegOnRPI, media)
 	if err != nil {
 		return nil, err
 	}
 
-	return GenerateHLSCustom(ffmpegPath, options)
+	return GenerateHLSCustom(ctx, ffmpegPath, options)
 }
 
 // GenerateHLSCustom will generate HLS using the flexible options params.s
 // options is array of string that accepted by ffmpeg command
-func GenerateHLSCustom(ffmpegPath string, options []string) (*exec.Cmd, error) {
-	cmd := exec.Command(ffmpegPath, options...)
+func GenerateHLSCustom(ctx context.Context, ffmpegPath string, options []string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath, options...)
 	log.Println("Executing:", cmd.String())
 	cmd.Stdout = log.Writer()
 	cmd.Stderr = log.Writer()
@@ -1,13 +1,36 @@
 package hls
 
-import "path/filepath"
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"piflix/internal/model"
+	"strconv"
+)
 
-func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool) ([]string, error) {
-	config, err := getConfig(res)
-	if err != nil {
-		return nil, err
+// getOptions builds the ffmpeg options for a resolution. When the probed
+// media info is given only the main video and audio stream are mapped, the
+// keyframe interval follows the source frame rate and the video is scaled
+// to the exact output size.
+func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool, media *model.MediaInfo) ([]string, error) {
+	var config *config
+
+	switch {
+	case res == SourceVariant && CanRemux(media):
+		return remuxOptions(srcPath, targetPath, media)
+	case res == SourceVariant && hasVideoSize(media):
+		config = sourceConfig(media)
+	default:
+		var err error
+		config, err = getConfig(res)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	streams := streamMaps(media)
+	gop := gopSize(media)
+
 	filenameTS := filepath.Join(targetPath, res+"_%03d.ts")
 	filenameM3U8 := filepath.Join(targetPath, res+".m3u8")
 
@@ -16,9 +39,12 @@ func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool) ([]string, er
 		options = []string{
 			"-hide_banner",
 			"-y",
+			"-nostats",
+			"-progress", "pipe:1",
 			"-i", srcPath,
-			"-map", "0",
-			"-map", "-0:s",
+			"-map", streams[0],
+			"-map", streams[1],
+			"-vf", scaleFilter(config, media),
 			"-c:a", "aac",
 			"-b:a", config.AudioBitrate,
 			"-ac", "2",
@@ -28,8 +54,8 @@ func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool) ([]string, er
 			"-crf", "20",
 			"-pix_fmt", "yuv420p",
 			"-sc_threshold", "0",
-			"-g", "48",
-			"-keyint_min", "48",
+			"-g", gop,
+			"-keyint_min", gop,
 			"-hls_time", "10",
 			"-hls_playlist_type", "vod",
 			"-b:v", config.VideoBitrate,
@@ -43,10 +69,12 @@ func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool) ([]string, er
 		options = []string{
 			"-hide_banner",
 			"-y",
+			"-nostats",
+			"-progress", "pipe:1",
 			"-i", srcPath,
-			"-map", "0",
-			"-map", "-0:s",
-			"-vf", "scale=trunc(oh*a/2)*2:1080",
+			"-map", streams[0],
+			"-map", streams[1],
+			"-vf", scaleFilter(config, media),
 			"-c:a", "aac",
 			"-b:a", config.AudioBitrate,
 			"-ac", "2",
@@ -55,8 +83,8 @@ func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool) ([]string, er
 			"-crf", "20",
 			"-pix_fmt", "yuv420p",
 			"-sc_threshold", "0",
-			"-g", "48",
-			"-keyint_min", "48",
+			"-g", gop,
+			"-keyint_min", gop,
 			"-hls_time", "10",
 			"-hls_playlist_type", "vod",
 			"-b:v", config.VideoBitrate,
@@ -70,3 +98,46 @@ func getOptions(srcPath, targetPath, res string, ffmpegOnRPI bool) ([]string, er
 
 	return options, nil
 }
+
+// streamMaps returns the two -map values. Without media info every stream
+// except the subtitles is mapped, otherwise the main video stream and the
+// default audio stream. The audio map is optional so that video without
+// sound works too.
+func streamMaps(media *model.MediaInfo) []string {
+	if media == nil {
+		return []string{"0", "-0:s"}
+	}
+
+	audio := "0:a:0?"
+	if stream := mainAudio(media); stream != nil {
+		audio = fmt.Sprintf("0:%d", stream.Index)
+	}
+
+	return []string{fmt.Sprintf("0:%d", media.Video.Index), audio}
+}
+
+// mainAudio returns the default audio stream or the first one, nil if there
+// is no audio.
+func mainAudio(media *model.MediaInfo) *model.MediaStream {
+	if media == nil || len(media.Audio) == 0 {
+		return nil
+	}
+
+	for i := range media.Audio {
+		if media.Audio[i].Default {
+			return &media.Audio[i]
+		}
+	}
+
+	return &media.Audio[0]
+}
+
+// gopSize puts a keyframe every two seconds so that the segments can be cut
+// evenly.
+func gopSize(media *model.MediaInfo) string {
+	if media == nil || media.Video.FrameRate <= 0 {
+		return "48"
+	}
+
+	return strconv.Itoa(int(math.Round(media.Video.FrameRate * 2)))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"piflix/internal/model"
 )
 
 // Variant is HLS variant that gonna be use to generate HLS master playlist
@@ -31,7 +32,9 @@ type Variant struct {
 
 // GenerateHLSVariant will generate variants info from the given resolutions.
 // The available resolutions are: 360p, 480p, 720p and 1080p.
-func GenerateHLSVariant(resOptions []string, locPrefix string) ([]*Variant, error) {
+// With the optional media info the resolution of each variant is the size
+// the source is scaled to.
+func GenerateHLSVariant(resOptions []string, locPrefix string, media *model.MediaInfo) ([]*Variant, error) {
 	if len(resOptions) == 0 {
 		return nil, errors.New("please give at least 1 resolution")
 	}
@@ -39,9 +42,22 @@ func GenerateHLSVariant(resOptions []string, locPrefix string) ([]*Variant, erro
 	var variants []*Variant
 
 	for _, r := range resOptions {
-		c, err := getConfig(r)
-		if err != nil {
+		var c *config
+
+		switch {
+		case r == SourceVariant && CanRemux(media):
+			variants = append(variants, sourceVariant(media, locPrefix))
+			continue
+		case r == SourceVariant && hasVideoSize(media):
+			c = sourceConfig(media)
+		case r == SourceVariant:
 			continue
+		default:
+			var err error
+			c, err = getConfig(r)
+			if err != nil {
+				continue
+			}
 		}
 
 		url := fmt.Sprintf("%s.m3u8", c.Name)
@@ -55,6 +71,11 @@ func GenerateHLSVariant(resOptions []string, locPrefix string) ([]*Variant, erro
 			Resolution: c.Resolution,
 		}
 
+		if hasVideoSize(media) {
+			width, height := outputSize(c, media)
+			v.Resolution = fmt.Sprintf("%dx%d", width, height)
+		}
+
 		variants = append(variants, v)
 	}
 
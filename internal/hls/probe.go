@@ -0,0 +1,164 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"piflix/internal/model"
+	"strconv"
+	"strings"
+)
+
+// ErrNoVideoStream is returned by Probe for files that have no video stream,
+// e.g. audio files or images, and for files that ffprobe can't read.
+var ErrNoVideoStream = errors.New("no video stream")
+
+type probeOutput struct {
+	Streams []probeStream `json:"streams"`
+	Format  struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+type probeStream struct {
+	Index         int                      `json:"index"`
+	CodecName     string                   `json:"codec_name"`
+	CodecType     string                   `json:"codec_type"`
+	Width         int                      `json:"width"`
+	Height        int                      `json:"height"`
+	PixFmt        string                   `json:"pix_fmt"`
+	ColorTransfer string                   `json:"color_transfer"`
+	AvgFrameRate  string                   `json:"avg_frame_rate"`
+	RFrameRate    string                   `json:"r_frame_rate"`
+	Channels      int                      `json:"channels"`
+	Disposition   map[string]int           `json:"disposition"`
+	Tags          map[string]string        `json:"tags"`
+	SideDataList  []map[string]interface{} `json:"side_data_list"`
+}
+
+// Probe runs ffprobe on the file and returns its container, main video stream
+// and audio and subtitle streams. ffprobe is killed when the context is done.
+// ffprobe exits with an error code for files it can't read, these are
+// reported as ErrNoVideoStream. Other errors, e.g. ffprobe not being found or
+// killed, are returned as they are.
+func Probe(ctx context.Context, ffprobePath, srcPath string) (*model.MediaInfo, error) {
+	output, err := exec.CommandContext(ctx, ffprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", srcPath).Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return nil, fmt.Errorf("%w: %s", ErrNoVideoStream, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var probe probeOutput
+
+	err = json.Unmarshal(output, &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProbe(&probe)
+}
+
+func parseProbe(probe *probeOutput) (*model.MediaInfo, error) {
+	info := &model.MediaInfo{
+		Container: probe.Format.FormatName,
+		Audio:     []model.MediaStream{},
+		Subtitles: []model.MediaStream{},
+	}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+
+	hasVideo := false
+
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Cover images are stored as video streams too.
+			if hasVideo || stream.Disposition["attached_pic"] == 1 {
+				continue
+			}
+
+			hasVideo = true
+			info.Video = model.VideoStream{
+				Index:       stream.Index,
+				Codec:       stream.CodecName,
+				Width:       stream.Width,
+				Height:      stream.Height,
+				FrameRate:   stream.frameRate(),
+				PixelFormat: stream.PixFmt,
+				HDR:         stream.hdr(),
+			}
+		case "audio", "subtitle":
+			mediaStream := model.MediaStream{
+				Index:    stream.Index,
+				Codec:    stream.CodecName,
+				Language: stream.Tags["language"],
+				Title:    stream.Tags["title"],
+				Channels: stream.Channels,
+				Default:  stream.Disposition["default"] == 1,
+			}
+
+			if stream.CodecType == "audio" {
+				info.Audio = append(info.Audio, mediaStream)
+			} else {
+				info.Subtitles = append(info.Subtitles, mediaStream)
+			}
+		}
+	}
+
+	if !hasVideo {
+		return nil, ErrNoVideoStream
+	}
+
+	return info, nil
+}
+
+// frameRate prefers the average frame rate, the real base frame rate is
+// often a multiple of it for interlaced or variable frame rate video.
+func (ps *probeStream) frameRate() float64 {
+	for _, rate := range []string{ps.AvgFrameRate, ps.RFrameRate} {
+		numerator, denominator, found := cut(rate, "/")
+		if !found {
+			continue
+		}
+
+		num, err := strconv.ParseFloat(numerator, 64)
+		if err != nil {
+			continue
+		}
+
+		den, err := strconv.ParseFloat(denominator, 64)
+		if err != nil || den == 0 || num == 0 {
+			continue
+		}
+
+		return num / den
+	}
+
+	return 0
+}
+
+func (ps *probeStream) hdr() string {
+	for _, sideData := range ps.SideDataList {
+		if sideDataType, _ := sideData["side_data_type"].(string); strings.HasPrefix(sideDataType, "DOVI") {
+			return "dolby_vision"
+		}
+	}
+
+	switch ps.ColorTransfer {
+	case "smpte2084":
+		return "hdr10"
+	case "arib-std-b67":
+		return "hlg"
+	default:
+		return ""
+	}
+}
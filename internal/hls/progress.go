@@ -0,0 +1,202 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress of a running ffmpeg command. Duration is the length of the source
+// and stays zero until ffmpeg reports it.
+type Progress struct {
+	Duration time.Duration
+	OutTime  time.Duration
+	Speed    float64
+	FPS      float64
+	Finished bool
+}
+
+// Percent returns how much of the source is processed, from 0 to 100.
+func (p Progress) Percent() float64 {
+	if p.Finished {
+		return 100
+	}
+
+	if p.Duration <= 0 {
+		return 0
+	}
+
+	percent := float64(p.OutTime) / float64(p.Duration) * 100
+	if percent > 100 {
+		return 100
+	}
+
+	return percent
+}
+
+// TimeLeft estimates how long the command still runs at its current speed.
+// It returns false while the duration or the speed is unknown.
+func (p Progress) TimeLeft() (time.Duration, bool) {
+	if p.Finished {
+		return 0, true
+	}
+
+	if p.Duration <= 0 || p.Speed <= 0 {
+		return 0, false
+	}
+
+	remaining := p.Duration - p.OutTime
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return time.Duration(float64(remaining) / p.Speed), true
+}
+
+// ProgressTracker parses the progress that ffmpeg writes with the -progress
+// option to stdout and the source duration from its log on stderr.
+type ProgressTracker struct {
+	mutex    sync.Mutex
+	progress Progress
+}
+
+// TrackProgress connects a tracker to the output of the command. It has to
+// be called before the command is started. The log is still written to the
+// previous stderr writer.
+func TrackProgress(cmd *exec.Cmd) *ProgressTracker {
+	tracker := &ProgressTracker{}
+
+	cmd.Stdout = &lineWriter{handle: tracker.parseProgressLine}
+
+	stderr := io.Writer(&lineWriter{handle: tracker.parseLogLine})
+	if cmd.Stderr != nil {
+		stderr = io.MultiWriter(cmd.Stderr, stderr)
+	}
+	cmd.Stderr = stderr
+
+	return tracker
+}
+
+func (pt *ProgressTracker) Progress() Progress {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	return pt.progress
+}
+
+func (pt *ProgressTracker) parseProgressLine(line string) {
+	key, value, found := cut(line, "=")
+	if !found {
+		return
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	switch key {
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			pt.progress.OutTime = time.Duration(us) * time.Microsecond
+		}
+	case "speed":
+		if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			pt.progress.Speed = speed
+		}
+	case "fps":
+		if fps, err := strconv.ParseFloat(value, 64); err == nil {
+			pt.progress.FPS = fps
+		}
+	case "progress":
+		pt.progress.Finished = value == "end"
+	}
+}
+
+// parseLogLine looks for the duration of the input, e.g.
+// "  Duration: 00:42:10.05, start: 0.000000, bitrate: 3000 kb/s".
+func (pt *ProgressTracker) parseLogLine(line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "Duration: ") {
+		return
+	}
+
+	value := strings.TrimPrefix(line, "Duration: ")
+	if index := strings.Index(value, ","); index >= 0 {
+		value = value[:index]
+	}
+
+	duration, ok := parseTimestamp(value)
+	if !ok {
+		return
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	if pt.progress.Duration == 0 {
+		pt.progress.Duration = duration
+	}
+}
+
+// parseTimestamp parses the HH:MM:SS.ss format used by ffmpeg.
+func parseTimestamp(value string) (time.Duration, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second)), true
+}
+
+func cut(s, separator string) (string, string, bool) {
+	index := strings.Index(s, separator)
+	if index < 0 {
+		return s, "", false
+	}
+
+	return s[:index], s[index+len(separator):], true
+}
+
+// lineWriter calls handle for every complete line written to it.
+type lineWriter struct {
+	buffer []byte
+	handle func(line string)
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buffer = append(lw.buffer, p...)
+
+	for {
+		index := bytes.IndexAny(lw.buffer, "\r\n")
+		if index < 0 {
+			break
+		}
+
+		line := string(lw.buffer[:index])
+		lw.buffer = lw.buffer[index+1:]
+
+		if len(line) > 0 {
+			lw.handle(line)
+		}
+	}
+
+	return len(p), nil
+}
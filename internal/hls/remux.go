@@ -0,0 +1,91 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"piflix/internal/model"
+	"strconv"
+)
+
+// SourceVariant is the name of the variant at the size of the source. Its
+// video is kept as it is when CanRemux allows it, otherwise it is only
+// rendered for sources that are smaller than every preset.
+const SourceVariant = "source"
+
+// remuxAudioBitrate is used when the audio of a remuxed source has to be
+// transcoded.
+const remuxAudioBitrate = "192k"
+
+// CanRemux reports whether the main video stream can be segmented without
+// transcoding. HLS players expect 8-bit H.264 in SDR.
+func CanRemux(media *model.MediaInfo) bool {
+	if !hasVideoSize(media) || len(media.Video.HDR) > 0 {
+		return false
+	}
+
+	video := media.Video
+
+	return video.Codec == "h264" && (video.PixelFormat == "yuv420p" || video.PixelFormat == "yuvj420p")
+}
+
+func canCopyAudio(stream *model.MediaStream) bool {
+	return stream != nil && (stream.Codec == "aac" || stream.Codec == "mp3")
+}
+
+// remuxOptions copies the video into segments. The audio is copied too when
+// it is AAC or MP3, otherwise it is transcoded to stereo AAC.
+func remuxOptions(srcPath, targetPath string, media *model.MediaInfo) ([]string, error) {
+	if !CanRemux(media) {
+		return nil, errors.New("source can't be remuxed")
+	}
+
+	streams := streamMaps(media)
+
+	options := []string{
+		"-hide_banner",
+		"-y",
+		"-nostats",
+		"-progress", "pipe:1",
+		"-i", srcPath,
+		"-map", streams[0],
+		"-map", streams[1],
+		"-c:v", "copy",
+	}
+
+	if canCopyAudio(mainAudio(media)) {
+		options = append(options, "-c:a", "copy")
+	} else {
+		options = append(options, "-c:a", "aac", "-b:a", remuxAudioBitrate, "-ac", "2")
+	}
+
+	options = append(options,
+		"-hls_time", "10",
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(targetPath, SourceVariant+"_%03d.ts"),
+		filepath.Join(targetPath, SourceVariant+".m3u8"),
+	)
+
+	return options, nil
+}
+
+// sourceVariant describes the remuxed source in the master playlist. Its
+// bandwidth is the bit rate of the source, or of the biggest preset when
+// ffprobe didn't report it.
+func sourceVariant(media *model.MediaInfo, locPrefix string) *Variant {
+	url := fmt.Sprintf("%s.m3u8", SourceVariant)
+	if locPrefix != "" {
+		url = locPrefix + "/" + url
+	}
+
+	bandwidth := preset["1080p"].Bandwidth
+	if media.BitRate > 0 {
+		bandwidth = strconv.FormatInt(media.BitRate, 10)
+	}
+
+	return &Variant{
+		URL:        url,
+		Bandwidth:  bandwidth,
+		Resolution: fmt.Sprintf("%dx%d", media.Video.Width, media.Video.Height),
+	}
+}
@@ -0,0 +1,77 @@
+package hls
+
+import (
+	"piflix/internal/model"
+	"testing"
+)
+
+func TestCanRemux(t *testing.T) {
+	tests := []struct {
+		name  string
+		video model.VideoStream
+		remux bool
+	}{
+		{"h264", model.VideoStream{Codec: "h264", Width: 1920, Height: 1080, PixelFormat: "yuv420p"}, true},
+		{"h264 full range", model.VideoStream{Codec: "h264", Width: 1920, Height: 1080, PixelFormat: "yuvj420p"}, true},
+		{"10-bit h264", model.VideoStream{Codec: "h264", Width: 1920, Height: 1080, PixelFormat: "yuv420p10le"}, false},
+		{"hdr", model.VideoStream{Codec: "h264", Width: 1920, Height: 1080, PixelFormat: "yuv420p", HDR: "hdr10"}, false},
+		{"hevc", model.VideoStream{Codec: "hevc", Width: 1920, Height: 1080, PixelFormat: "yuv420p"}, false},
+		{"unknown size", model.VideoStream{Codec: "h264", PixelFormat: "yuv420p"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if CanRemux(&model.MediaInfo{Video: test.video}) != test.remux {
+				t.Errorf("CanRemux(%+v) is %t", test.video, !test.remux)
+			}
+		})
+	}
+
+	if CanRemux(nil) {
+		t.Error("a file without media info can be remuxed")
+	}
+}
+
+func TestRemuxOptionsCopyAudio(t *testing.T) {
+	media := &model.MediaInfo{
+		Video: model.VideoStream{Index: 0, Codec: "h264", Width: 1920, Height: 1080, PixelFormat: "yuv420p"},
+		Audio: []model.MediaStream{{Index: 1, Codec: "ac3"}, {Index: 2, Codec: "aac", Default: true}},
+	}
+
+	options, err := remuxOptions("movie.mkv", "out", media)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !containsSequence(options, "-map", "0:2") || !containsSequence(options, "-c:a", "copy") {
+		t.Errorf("options %v don't copy the default audio stream", options)
+	}
+
+	media.Audio[1].Codec = "dts"
+
+	options, err = remuxOptions("movie.mkv", "out", media)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !containsSequence(options, "-c:a", "aac") {
+		t.Errorf("options %v don't transcode the audio", options)
+	}
+
+	media.Video.Codec = "hevc"
+
+	_, err = remuxOptions("movie.mkv", "out", media)
+	if err == nil {
+		t.Error("expected an error for a source that can't be remuxed")
+	}
+}
+
+func containsSequence(options []string, name string, value string) bool {
+	for i := 0; i+1 < len(options); i++ {
+		if options[i] == name && options[i+1] == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,14 +14,37 @@ import (
 	"piflix/internal/model"
 	"piflix/internal/utility"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
 
-	"github.com/h2non/filetype"
+var (
+	errRenderStopped   = errors.New("render was stopped")
+	errNoVideoFiles    = errors.New("no video files")
+	errNotRenderable   = errors.New("torrent can't be rendered in its current status")
+	errSourceFilesGone = errors.New("downloaded files of the torrent don't exist anymore")
+	errUnknownPreset   = errors.New("unknown resolution")
 )
 
+// renderPollInterval is how often idle workers look for retried jobs that
+// became due.
+const renderPollInterval = 10 * time.Second
+
+// HLSManager renders downloaded torrents with a fixed number of workers. The
+// IDs sent to RenderQueueChan are stored as render jobs so that the queue
+// survives restarts. Each running job can be stopped through its entry in
+// cancels, which kills the running ffprobe or ffmpeg and keeps the next ones
+// from starting.
 type HLSManager struct {
 	RenderQueueChan chan string
 	database        *db.SQLite
+	mutex           sync.Mutex
 	activeCommands  map[string]*exec.Cmd
+	cancels         map[string]context.CancelFunc
+	suspended       bool
+	jobAdded        chan struct{}
+	renders         map[string]*renderState
 	config          *Config
 }
 
@@ -27,73 +53,226 @@ func NewHLSManager(database *db.SQLite, config *Config) *HLSManager {
 		RenderQueueChan: make(chan string),
 		database:        database,
 		activeCommands:  map[string]*exec.Cmd{},
+		cancels:         map[string]context.CancelFunc{},
+		jobAdded:        make(chan struct{}, config.RenderWorkers),
+		renders:         map[string]*renderState{},
 		config:          config,
 	}
 
+	err := database.RequeueRunningRenderJobs()
+	if err != nil {
+		log.Println("Couldn't requeue interrupted renders. Error:", err)
+	}
+
 	go hlsManager.start()
 
+	for i := 0; i < config.RenderWorkers; i++ {
+		go hlsManager.runWorker()
+	}
+
 	return hlsManager
 }
 
 func (hlsm *HLSManager) checkDependencies() bool {
-	return utility.CommandExists(hlsm.config.FfmpegPath)
+	return utility.CommandExists(hlsm.config.FfmpegPath) && utility.CommandExists(hlsm.config.FfprobePath)
 }
 
 func (hlsm *HLSManager) start() {
 	for {
 		id := <-hlsm.RenderQueueChan
 
-		torrent, err := hlsm.database.TorrentWithID(id)
+		err := hlsm.enqueue(id)
 		if err != nil {
-			log.Println("HLSManager start() error:", err)
-			continue
+			log.Println("Couldn't queue render of torrent", id, "Error:", err)
 		}
+	}
+}
+
+// enqueue adds a render job for the torrent and wakes up an idle worker.
+func (hlsm *HLSManager) enqueue(ID string) error {
+	err := hlsm.database.EnqueueRenderJob(ID)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case hlsm.jobAdded <- struct{}{}:
+	default:
+	}
 
-		err = createDirectory(torrent, hlsm.config.WorkDir)
+	return nil
+}
+
+func (hlsm *HLSManager) runWorker() {
+	for {
+		job, err := hlsm.nextJob()
 		if err != nil {
-			log.Println("HLSManager start() error:", err)
+			if err != sql.ErrNoRows {
+				log.Println("Couldn't get the next render job. Error:", err)
+			}
+
+			select {
+			case <-hlsm.jobAdded:
+			case <-time.After(renderPollInterval):
+			}
+
 			continue
 		}
 
-		go hlsm.startRender(torrent)
+		hlsm.runJob(job)
+	}
+}
+
+// nextJob is serialized so that two workers never take the same job.
+func (hlsm *HLSManager) nextJob() (*model.RenderJob, error) {
+	hlsm.mutex.Lock()
+	defer hlsm.mutex.Unlock()
+
+	return hlsm.database.NextRenderJob()
+}
+
+// runJob renders the torrent of the job. A failed render is queued again
+// with a growing delay until it runs out of attempts.
+func (hlsm *HLSManager) runJob(job *model.RenderJob) {
+	// The render can be stopped before the torrent is read. A torrent that
+	// is deleted before that isn't found.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hlsm.mutex.Lock()
+	hlsm.cancels[job.TorrentID] = cancel
+	hlsm.mutex.Unlock()
+
+	defer func() {
+		hlsm.mutex.Lock()
+		delete(hlsm.cancels, job.TorrentID)
+		hlsm.mutex.Unlock()
+	}()
+
+	torrent, err := hlsm.database.TorrentWithID(job.TorrentID)
+	if err != nil {
+		hlsm.database.FinishRenderJob(model.RenderJobStatusFailed, err.Error(), job.ID)
+		return
+	}
+
+	err = createDirectory(torrent, hlsm.config.WorkDir)
+	if err == nil {
+		err = hlsm.startRender(ctx, torrent)
+	}
+
+	switch {
+	case err == nil:
+		hlsm.database.SetStatusForTorrent(model.TorrentStatusReady, torrent.ID)
+		hlsm.database.FinishRenderJob(model.RenderJobStatusDone, "", job.ID)
+	case err == errRenderStopped:
+		// The directory may have been created again after the torrent was
+		// deleted.
+		os.RemoveAll(filepath.Join(hlsm.config.WorkDir, "media", torrent.ID))
+		hlsm.database.FinishRenderJob(model.RenderJobStatusFailed, err.Error(), job.ID)
+		return
+	case err == errNoVideoFiles:
+		hlsm.database.SetFailureForTorrent(err.Error(), torrent.ID)
+		hlsm.database.FinishRenderJob(model.RenderJobStatusFailed, err.Error(), job.ID)
+	case job.Attempts <= hlsm.config.RenderRetries && hlsm.sourceFilesExist(torrent):
+		delay := hlsm.config.RenderRetryDelay << (job.Attempts - 1)
+		log.Println("Rendering of torrent", torrent.ID, "failed, retrying in", delay, "Error:", err)
+		hlsm.database.RetryRenderJob(err.Error(), time.Now().Add(delay), job.ID)
+		return
+	default:
+		// The downloaded files are kept so that the torrent can be rendered
+		// again.
+		log.Println("Rendering of torrent", torrent.ID, "failed. Error:", err)
+		hlsm.database.SetRenderFailureForTorrent(err.Error(), torrent.ID)
+		hlsm.database.FinishRenderJob(model.RenderJobStatusFailed, err.Error(), job.ID)
+		return
+	}
+
+	// Files of a torrent that is still seeding are deleted once seeding stops.
+	// The seeding flag is read after the status is updated so that either
+	// the renderer or the seeding torrent sees the other one as finished.
+	if hlsm.database.IsTorrentSeeding(torrent.ID) {
+		return
 	}
+
+	utility.DeleteDownloadedFiles(torrent, hlsm.config.WorkDir)
 }
 
-func (hlsm *HLSManager) startRender(torrent *model.Torrent) {
+func (hlsm *HLSManager) startRender(ctx context.Context, torrent *model.Torrent) error {
 	targetBasePath := filepath.Join(hlsm.config.WorkDir, "media", torrent.ID)
 	os.RemoveAll(targetBasePath)
 
-	validFiles := 0
+	fileIndexes := []int{}
+	media := []*model.MediaInfo{}
+	resolutions := [][]string{}
 
 	for index, file := range torrent.Files {
-		if !isFileVideo(file.Path, hlsm.config.WorkDir) {
-			log.Println("File", file.Path, "is not a video. Deleting it from database.")
+		if ctx.Err() != nil {
+			return errRenderStopped
+		}
+
+		info, err := hls.Probe(ctx, hlsm.config.FfprobePath, filepath.Join(hlsm.config.WorkDir, "downloads", file.Path))
+		if ctx.Err() != nil {
+			return errRenderStopped
+		}
+
+		if errors.Is(err, hls.ErrNoVideoStream) {
+			log.Println("File", file.Path, "is not a video, deleting it from database:", err)
 			hlsm.database.DeleteFile(&file)
 			continue
 		}
 
-		validFiles += 1
+		if err != nil {
+			return fmt.Errorf("probing %s failed: %v", file.Path, err)
+		}
 
-		err := hlsm.startFileRender(torrent, &file, index)
+		err = hlsm.database.SaveMediaInfo(info, file.ID)
 		if err != nil {
-			os.RemoveAll(targetBasePath)
-			return
+			log.Println("Couldn't save media info of file", file.Path, "Error:", err)
 		}
+
+		fileIndexes = append(fileIndexes, index)
+		media = append(media, info)
+		resolutions = append(resolutions, hls.SourceResolutions(hlsm.resolutions(torrent), info))
 	}
 
-	if validFiles == 0 {
-		hlsm.database.DeleteTorrent(torrent)
-	} else {
-		hlsm.database.SetStatusForTorrent(model.TorrentStatusReady, torrent.ID)
+	if len(fileIndexes) == 0 {
+		return errNoVideoFiles
 	}
 
-	utility.DeleteDownloadedFiles(torrent, hlsm.config.WorkDir)
+	state := newRenderState(torrent, fileIndexes, resolutions)
+
+	hlsm.mutex.Lock()
+	hlsm.renders[torrent.ID] = state
+	hlsm.mutex.Unlock()
+
+	defer func() {
+		hlsm.mutex.Lock()
+		delete(hlsm.renders, torrent.ID)
+		hlsm.mutex.Unlock()
+	}()
+
+	for i, index := range fileIndexes {
+		file := &torrent.Files[index]
+
+		err := hlsm.startFileRender(ctx, torrent, file, index, media[i], state.files[i])
+		if err != nil {
+			os.RemoveAll(targetBasePath)
+
+			if err == errRenderStopped {
+				return err
+			}
+
+			return fmt.Errorf("rendering %s failed: %v", file.Path, err)
+		}
+	}
+
+	return nil
 }
 
-func (hlsm *HLSManager) startFileRender(torrent *model.Torrent, file *model.File, fileIndex int) error {
+func (hlsm *HLSManager) startFileRender(ctx context.Context, torrent *model.Torrent, file *model.File, fileIndex int, media *model.MediaInfo, state *fileRenderState) error {
 	srcPath := filepath.Join(hlsm.config.WorkDir, "downloads", file.Path)
 	targetPath := filepath.Join(hlsm.config.WorkDir, "media", torrent.ID, fmt.Sprint(fileIndex))
-	resOptions := strings.Split(utility.StripSpaces(hlsm.config.Resolutions), ",")
+	resOptions := state.resolutions
 
 	err := os.MkdirAll(targetPath, os.ModePerm)
 	if err != nil {
@@ -101,68 +280,176 @@ func (hlsm *HLSManager) startFileRender(torrent *model.Torrent, file *model.File
 		return err
 	}
 
-	variants, _ := hls.GenerateHLSVariant(resOptions, "")
-	hls.GeneratePlaylist(variants, targetPath, "")
+	for resIndex, res := range resOptions {
+		if ctx.Err() != nil {
+			return errRenderStopped
+		}
 
-	for _, res := range resOptions {
-		cmd, err := hls.GenerateHLS(hlsm.config.FfmpegPath, srcPath, targetPath, res, hlsm.config.FFmpegPI)
+		cmd, err := hls.GenerateHLS(ctx, hlsm.config.FfmpegPath, srcPath, targetPath, res, hlsm.config.FFmpegPI, media)
 		if err != nil {
 			log.Println("HLS generation returned error:", err)
 			return err
 		}
 
+		tracker := hls.TrackProgress(cmd)
+
 		err = cmd.Start()
 		if err != nil {
 			return err
 		}
 
+		hlsm.mutex.Lock()
 		hlsm.activeCommands[torrent.ID] = cmd
+		state.trackers[resIndex] = tracker
+		if hlsm.suspended {
+			cmd.Process.Signal(syscall.SIGSTOP)
+		}
+		hlsm.mutex.Unlock()
 
 		err = cmd.Wait()
+
+		hlsm.mutex.Lock()
 		delete(hlsm.activeCommands, torrent.ID)
+		hlsm.mutex.Unlock()
+
+		if ctx.Err() != nil {
+			return errRenderStopped
+		}
+
 		if err != nil {
 			return err
 		}
+
+		hlsm.mutex.Lock()
+		state.done = resIndex + 1
+		hlsm.mutex.Unlock()
+	}
+
+	if ctx.Err() != nil {
+		return errRenderStopped
+	}
+
+	// The master playlist lists only the variants that were rendered.
+	variants, err := hls.GenerateHLSVariant(resOptions, "", media)
+	if err != nil {
+		return err
 	}
 
+	hls.GeneratePlaylist(variants, targetPath, "")
+
 	return nil
 }
 
-func (hlsm *HLSManager) stopProcessing(ID string) error {
-	cmd := hlsm.activeCommands[ID]
-	if cmd == nil {
-		return nil
+// Rerender renders a ready torrent or one whose rendering failed again from
+// its downloaded files. Empty resolutions keep the ones used before.
+func (hlsm *HLSManager) Rerender(ID string, resolutions []string) error {
+	for _, res := range resolutions {
+		if !hls.HasPreset(res) {
+			return fmt.Errorf("%w %q", errUnknownPreset, res)
+		}
+	}
+
+	hlsm.mutex.Lock()
+	defer hlsm.mutex.Unlock()
+
+	torrent, err := hlsm.database.TorrentWithID(ID)
+	if err != nil {
+		return err
 	}
 
-	err := cmd.Process.Kill()
+	if torrent.Status != model.TorrentStatusReady && torrent.Status != model.TorrentStatusRenderFailed {
+		return errNotRenderable
+	}
 
-	delete(hlsm.activeCommands, ID)
+	if !hlsm.sourceFilesExist(torrent) {
+		return errSourceFilesGone
+	}
 
-	return err
+	if len(resolutions) > 0 {
+		err = hlsm.database.SetResolutionsForTorrent(strings.Join(resolutions, ","), ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = hlsm.database.SetStatusForTorrent(model.TorrentStatusRendering, ID)
+	if err != nil {
+		return err
+	}
+
+	return hlsm.enqueue(ID)
 }
 
-func createDirectory(torrent *model.Torrent, workDir string) error {
-	path := filepath.Join(workDir, "media", torrent.ID)
-	err := os.MkdirAll(path, os.ModePerm)
+func (hlsm *HLSManager) sourceFilesExist(torrent *model.Torrent) bool {
+	for _, file := range torrent.Files {
+		_, err := os.Stat(filepath.Join(hlsm.config.WorkDir, "downloads", file.Path))
+		if err != nil {
+			return false
+		}
+	}
 
-	return err
+	return true
 }
 
-func isFileVideo(path string, workDir string) bool {
-	srcPath := filepath.Join(workDir, "downloads", path)
+func (hlsm *HLSManager) resolutions(torrent *model.Torrent) []string {
+	resolutions := torrent.Resolutions
+	if len(resolutions) == 0 {
+		resolutions = hlsm.config.Resolutions
+	}
 
-	file, err := os.Open(srcPath)
-	if err != nil {
-		log.Println("Couldn't open file to check if it is video. Error:", err)
-		return false
+	return strings.Split(utility.StripSpaces(resolutions), ",")
+}
+
+// RenderProgress returns the progress of the torrents that are rendered right
+// now.
+func (hlsm *HLSManager) RenderProgress() []model.RenderProgress {
+	hlsm.mutex.Lock()
+	defer hlsm.mutex.Unlock()
+
+	renders := []model.RenderProgress{}
+
+	for _, state := range hlsm.renders {
+		renders = append(renders, state.progress())
 	}
 
-	head := make([]byte, 261)
-	_, err = file.Read(head)
-	if err != nil {
-		log.Println("Couldn't read file to check if it is video. Error:", err)
-		return false
+	return renders
+}
+
+// SetSuspended stops the running ffmpeg processes, and the ones started while
+// suspended, until it is called again with false.
+func (hlsm *HLSManager) SetSuspended(suspended bool) {
+	hlsm.mutex.Lock()
+	defer hlsm.mutex.Unlock()
+
+	hlsm.suspended = suspended
+
+	signal := syscall.SIGCONT
+	if suspended {
+		signal = syscall.SIGSTOP
+	}
+
+	for ID, cmd := range hlsm.activeCommands {
+		err := cmd.Process.Signal(signal)
+		if err != nil {
+			log.Println("Couldn't signal render of torrent", ID, "Error:", err)
+		}
 	}
+}
 
-	return filetype.IsVideo(head)
+// stopProcessing stops the render of the torrent wherever it is, the running
+// ffprobe or ffmpeg is killed and no further file or resolution is started.
+func (hlsm *HLSManager) stopProcessing(ID string) {
+	hlsm.mutex.Lock()
+	defer hlsm.mutex.Unlock()
+
+	if cancel, ok := hlsm.cancels[ID]; ok {
+		cancel()
+	}
+}
+
+func createDirectory(torrent *model.Torrent, workDir string) error {
+	path := filepath.Join(workDir, "media", torrent.ID)
+	err := os.MkdirAll(path, os.ModePerm)
+
+	return err
 }
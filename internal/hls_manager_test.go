@@ -0,0 +1,435 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"piflix/internal/db"
+	"piflix/internal/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProbeOutput describes a single 1080p video stream.
+const fakeProbeOutput = `{"streams":[{"index":0,"codec_name":"hevc","codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"24/1"}],"format":{"format_name":"matroska","duration":"60"}}`
+
+// fakeFFprobe reports the same video stream for every file.
+const fakeFFprobe = "#!/bin/sh\necho '" + fakeProbeOutput + "'\n"
+
+// fakeFFmpeg renders until it is killed.
+const fakeFFmpeg = `#!/bin/sh
+exec sleep 60
+`
+
+func newTestHLSManager(t *testing.T) *HLSManager {
+	t.Helper()
+
+	workDir := t.TempDir()
+
+	config := &Config{
+		WorkDir:       workDir,
+		FfmpegPath:    filepath.Join(workDir, "ffmpeg"),
+		FfprobePath:   filepath.Join(workDir, "ffprobe"),
+		Resolutions:   "720p,480p",
+		RenderWorkers: 4,
+	}
+
+	for path, script := range map[string]string{config.FfmpegPath: fakeFFmpeg, config.FfprobePath: fakeFFprobe} {
+		err := os.WriteFile(path, []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	database := db.NewSQLiteDatabase(workDir)
+	if database == nil {
+		t.Fatal("couldn't create database")
+	}
+
+	return NewHLSManager(database, config)
+}
+
+// addDownloadedTorrent saves a torrent that is waiting to be rendered with a
+// single downloaded file.
+func addDownloadedTorrent(t *testing.T, hlsManager *HLSManager, n int) string {
+	t.Helper()
+
+	id := fmt.Sprintf("torrent-%d", n)
+	path := fmt.Sprintf("Movie %d.mkv", n)
+
+	err := hlsManager.database.SaveTorrent(&model.Torrent{
+		ID:        id,
+		Hash:      fmt.Sprintf("%040x", n),
+		Name:      path,
+		Status:    model.TorrentStatusRendering,
+		AddedTime: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hlsManager.database.SetInfoForTorrent(path, []model.File{{TorrentID: id, Path: path, Length: 1, Selected: true}}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloads := filepath.Join(hlsManager.config.WorkDir, "downloads")
+	err = os.MkdirAll(downloads, os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(downloads, path), []byte{0}, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// TestHLSManagerConcurrentStop stops the renders of the workers while their
+// progress is read and they are suspended and resumed. It is meant to be run
+// with the race detector.
+func TestHLSManagerConcurrentStop(t *testing.T) {
+	hlsManager := newTestHLSManager(t)
+
+	ids := []string{}
+	for i := 0; i < 8; i++ {
+		ids = append(ids, addDownloadedTorrent(t, hlsManager, i))
+	}
+
+	for _, id := range ids {
+		hlsManager.RenderQueueChan <- id
+	}
+
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				hlsManager.RenderProgress()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Millisecond):
+				}
+
+				for _, id := range ids {
+					hlsManager.stopProcessing(id)
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for suspended := true; ; suspended = !suspended {
+			select {
+			case <-stop:
+				hlsManager.SetSuspended(false)
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+
+			hlsManager.SetSuspended(suspended)
+		}
+	}()
+
+	// Every render is stopped, so each job ends up failed.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		jobs, err := hlsManager.database.GetRenderJobs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		failed := 0
+		for _, job := range jobs {
+			if job.Status == model.RenderJobStatusFailed {
+				failed++
+			}
+		}
+
+		if failed == len(ids) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d render jobs were stopped: %+v", failed, len(ids), jobs)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if renders := hlsManager.RenderProgress(); len(renders) != 0 {
+		t.Errorf("%d renders are still in progress", len(renders))
+	}
+
+	hlsManager.mutex.Lock()
+	commands := len(hlsManager.activeCommands)
+	hlsManager.mutex.Unlock()
+
+	if commands != 0 {
+		t.Errorf("%d render commands are still active", commands)
+	}
+}
+
+// writeScript replaces a fake ffmpeg or ffprobe.
+func writeScript(t *testing.T, path string, script string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deleteRenderingTorrent deletes the torrent like the delete handler does.
+func deleteRenderingTorrent(t *testing.T, hlsManager *HLSManager, id string) {
+	t.Helper()
+
+	torrent, err := hlsManager.database.TorrentWithID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hlsManager.database.DeleteTorrent(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hlsManager.stopProcessing(id)
+	os.RemoveAll(filepath.Join(hlsManager.config.WorkDir, "media", id))
+}
+
+// waitForFile waits until the fake ffmpeg or ffprobe created the file.
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%s wasn't created", path)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForRenders waits until no render job is running anymore.
+func waitForRenders(t *testing.T, hlsManager *HLSManager) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		hlsManager.mutex.Lock()
+		running := len(hlsManager.cancels)
+		hlsManager.mutex.Unlock()
+
+		if running == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d renders are still running", running)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHLSManagerStopWhileProbing(t *testing.T) {
+	hlsManager := newTestHLSManager(t)
+
+	probing := filepath.Join(hlsManager.config.WorkDir, "probing")
+	rendered := filepath.Join(hlsManager.config.WorkDir, "rendered")
+
+	writeScript(t, hlsManager.config.FfprobePath, fmt.Sprintf("#!/bin/sh\ntouch %q\nexec sleep 60\n", probing))
+	writeScript(t, hlsManager.config.FfmpegPath, fmt.Sprintf("#!/bin/sh\ntouch %q\n", rendered))
+
+	id := addDownloadedTorrent(t, hlsManager, 1)
+	hlsManager.RenderQueueChan <- id
+
+	waitForFile(t, probing)
+	deleteRenderingTorrent(t, hlsManager, id)
+	waitForRenders(t, hlsManager)
+
+	if _, err := os.Stat(rendered); err == nil {
+		t.Error("ffmpeg was started after the render was stopped")
+	}
+
+	if _, err := os.Stat(filepath.Join(hlsManager.config.WorkDir, "media", id)); err == nil {
+		t.Error("media directory of the deleted torrent exists")
+	}
+}
+
+func TestHLSManagerDeleteBetweenResolutions(t *testing.T) {
+	hlsManager := newTestHLSManager(t)
+
+	workDir := hlsManager.config.WorkDir
+	runs := filepath.Join(workDir, "runs")
+	started := filepath.Join(workDir, "started")
+	release := filepath.Join(workDir, "release")
+	exited := filepath.Join(workDir, "exited")
+
+	// Every run is logged, the first one renders until it is released.
+	writeScript(t, hlsManager.config.FfmpegPath, fmt.Sprintf(`#!/bin/sh
+echo run >> %q
+touch %q
+while [ ! -e %q ]; do sleep 0.01; done
+touch %q
+`, runs, started, release, exited))
+
+	id := addDownloadedTorrent(t, hlsManager, 1)
+	hlsManager.RenderQueueChan <- id
+
+	waitForFile(t, started)
+
+	// The worker waits for the mutex once the first resolution is rendered,
+	// so the torrent is deleted before the next one is started.
+	hlsManager.mutex.Lock()
+	writeScript(t, release, "")
+	waitForFile(t, exited)
+
+	torrent, err := hlsManager.database.TorrentWithID(id)
+	if err == nil {
+		err = hlsManager.database.DeleteTorrent(torrent)
+	}
+	if err != nil {
+		hlsManager.mutex.Unlock()
+		t.Fatal(err)
+	}
+
+	hlsManager.cancels[id]()
+	hlsManager.mutex.Unlock()
+
+	os.RemoveAll(filepath.Join(workDir, "media", id))
+
+	waitForRenders(t, hlsManager)
+
+	output, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(output) != "run\n" {
+		t.Errorf("ffmpeg ran %d times, expected once", strings.Count(string(output), "run"))
+	}
+
+	if _, err := os.Stat(filepath.Join(workDir, "media", id)); err == nil {
+		t.Error("media directory of the deleted torrent was created again")
+	}
+}
+
+func TestHLSManagerSkipsUnreadableFiles(t *testing.T) {
+	hlsManager := newTestHLSManager(t)
+
+	// ffprobe fails for the file it can't read like the real one does.
+	writeScript(t, hlsManager.config.FfprobePath, `#!/bin/sh
+case "$*" in
+*Broken*)
+	echo "Broken.mkv: Invalid data found when processing input" >&2
+	exit 1
+	;;
+esac
+echo '`+fakeProbeOutput+`'
+`)
+	writeScript(t, hlsManager.config.FfmpegPath, "#!/bin/sh\n")
+
+	id := addDownloadedTorrent(t, hlsManager, 1)
+
+	err := hlsManager.database.SetInfoForTorrent("Movie 1", []model.File{{TorrentID: id, Path: "Broken.mkv", Length: 1, Selected: true}}, id)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(hlsManager.config.WorkDir, "downloads", "Broken.mkv"), []byte{0}, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hlsManager.RenderQueueChan <- id
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		torrent, err := hlsManager.database.TorrentWithID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if torrent.Status == model.TorrentStatusReady {
+			if len(torrent.Files) != 1 || torrent.Files[0].Path != "Movie 1.mkv" {
+				t.Errorf("files are %+v, expected only the movie", torrent.Files)
+			}
+
+			break
+		}
+
+		if torrent.Status != model.TorrentStatusRendering || time.Now().After(deadline) {
+			t.Fatalf("torrent has status %d: %s", torrent.Status, torrent.Failure.String)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHLSManagerFailsWithoutFFprobe(t *testing.T) {
+	hlsManager := newTestHLSManager(t)
+	hlsManager.config.RenderRetries = 0
+
+	err := os.Remove(hlsManager.config.FfprobePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := addDownloadedTorrent(t, hlsManager, 1)
+	hlsManager.RenderQueueChan <- id
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		torrent, err := hlsManager.database.TorrentWithID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if torrent.Status == model.TorrentStatusRenderFailed {
+			if len(torrent.Files) != 1 {
+				t.Errorf("file of the torrent was deleted")
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("torrent has status %d, expected it to fail rendering", torrent.Status)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
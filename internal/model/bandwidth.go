@@ -0,0 +1,11 @@
+package model
+
+// BandwidthLimits are in KiB/s, zero means unlimited.
+type BandwidthLimits struct {
+	DownloadLimit int `json:"download_limit"`
+	UploadLimit   int `json:"upload_limit"`
+}
+
+type TorrentLimitRequest struct {
+	DownloadLimit int `json:"download_limit"`
+}
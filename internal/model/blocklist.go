@@ -0,0 +1,11 @@
+package model
+
+type BlocklistStats struct {
+	Ranges int `json:"ranges"`
+	// Hits counts the lookups that matched a range, which includes repeated
+	// lookups of the same address.
+	Hits int64 `json:"hits"`
+	// BlockedAddresses counts the distinct addresses that connections or
+	// connection attempts were refused for.
+	BlockedAddresses int `json:"blocked_addresses"`
+}
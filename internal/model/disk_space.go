@@ -0,0 +1,9 @@
+package model
+
+// DiskSpace is the state of the free space in the working directory.
+type DiskSpace struct {
+	Free    int64  `json:"free"`
+	MinFree int64  `json:"min_free"`
+	Low     bool   `json:"low"`
+	Error   string `json:"error,omitempty"`
+}
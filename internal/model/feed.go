@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+type Feed struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	URL         string     `json:"url"`
+	Enabled     bool       `json:"enabled"`
+	LastChecked *time.Time `json:"last_checked"`
+	LastError   NullString `json:"last_error"`
+	Rules       []FeedRule `json:"rules"`
+}
+
+// FeedRule selects the feed items that are downloaded. An item is downloaded
+// when it passes all filters of any rule of its feed.
+type FeedRule struct {
+	ID     int64  `json:"id"`
+	FeedID int64  `json:"-"`
+	Name   string `json:"name"`
+
+	// Include and Exclude are case insensitive regular expressions that are
+	// matched against the item title.
+	Include string `json:"include"`
+	Exclude string `json:"exclude"`
+
+	// Quality is a comma separated list of accepted qualities that have to
+	// appear in the item title, e.g. 720p,1080p.
+	Quality string `json:"quality"`
+
+	// MinSize and MaxSize are in MiB, zero means no bound. Items whose feed
+	// doesn't publish the size aren't filtered by it.
+	MinSize int64 `json:"min_size"`
+	MaxSize int64 `json:"max_size"`
+}
+
+type FeedRequest struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Enabled *bool  `json:"enabled"`
+}
@@ -4,5 +4,15 @@ type File struct {
 	ID        int64      `json:"id"`
 	TorrentID string     `json:"-"`
 	Path      string     `json:"path"`
+	Length    int64      `json:"length"`
+	Selected  bool       `json:"selected"`
 	Subtitle  NullString `json:"subtitle"`
+
+	// Media is only set for rendered files when the torrent is requested on
+	// its own.
+	Media *MediaInfo `json:"media,omitempty"`
+}
+
+type FileSelectionRequest struct {
+	Files []int64 `json:"files"`
 }
@@ -0,0 +1,35 @@
+package model
+
+// MediaInfo is what ffprobe found in a video file. Duration is in seconds
+// and BitRate is the overall bit rate in bits/s.
+type MediaInfo struct {
+	Duration  float64       `json:"duration"`
+	BitRate   int64         `json:"bit_rate"`
+	Container string        `json:"container"`
+	Video     VideoStream   `json:"video"`
+	Audio     []MediaStream `json:"audio"`
+	Subtitles []MediaStream `json:"subtitles"`
+}
+
+// VideoStream is the main video stream of a file. HDR is empty for SDR
+// video, otherwise one of hdr10, hlg or dolby_vision.
+type VideoStream struct {
+	Index       int     `json:"index"`
+	Codec       string  `json:"codec"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	FrameRate   float64 `json:"frame_rate"`
+	PixelFormat string  `json:"pixel_format"`
+	HDR         string  `json:"hdr"`
+}
+
+// MediaStream is an audio or subtitle stream. Channels is zero for
+// subtitles.
+type MediaStream struct {
+	Index    int    `json:"index"`
+	Codec    string `json:"codec"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Channels int    `json:"channels"`
+	Default  bool   `json:"default"`
+}
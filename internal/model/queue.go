@@ -0,0 +1,5 @@
+package model
+
+type QueuePositionRequest struct {
+	Position int `json:"position"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+type RenderJobStatus int
+
+const (
+	RenderJobStatusQueued RenderJobStatus = iota
+	RenderJobStatusRunning
+	RenderJobStatusDone
+	RenderJobStatusFailed
+)
+
+// RenderJob is a pending or finished render of a torrent. A failed attempt
+// that is retried puts the job back into the queue until RunAfter.
+type RenderJob struct {
+	ID           int64           `json:"id"`
+	TorrentID    string          `json:"torrent_id"`
+	Status       RenderJobStatus `json:"status"`
+	Attempts     int             `json:"attempts"`
+	Error        NullString      `json:"error"`
+	CreatedTime  time.Time       `json:"created_time"`
+	RunAfter     time.Time       `json:"run_after"`
+	StartedTime  *time.Time      `json:"started_time"`
+	FinishedTime *time.Time      `json:"finished_time"`
+
+	// Position is the 1-based place of a queued job in the queue, zero for
+	// the other ones.
+	Position int `json:"position"`
+}
@@ -0,0 +1,30 @@
+package model
+
+// RenderProgress is the progress of a torrent that is being rendered.
+// Progress is in percent and ETA in seconds, null while it can't be
+// estimated.
+type RenderProgress struct {
+	ID       string               `json:"id"`
+	Name     string               `json:"name"`
+	Progress int32                `json:"progress"`
+	ETA      *int64               `json:"eta"`
+	Files    []FileRenderProgress `json:"files"`
+}
+
+type FileRenderProgress struct {
+	ID          int64                `json:"id"`
+	Path        string               `json:"path"`
+	Progress    int32                `json:"progress"`
+	ETA         *int64               `json:"eta"`
+	Resolutions []ResolutionProgress `json:"resolutions"`
+}
+
+// ResolutionProgress is the progress of a single resolution of a file. Speed
+// is relative to the playback speed.
+type ResolutionProgress struct {
+	Resolution string  `json:"resolution"`
+	Progress   int32   `json:"progress"`
+	ETA        *int64  `json:"eta"`
+	Speed      float64 `json:"speed"`
+	FPS        float64 `json:"fps"`
+}
@@ -0,0 +1,69 @@
+package model
+
+// TransferRates are the rates of all active torrents together in bytes/s.
+type TransferRates struct {
+	DownloadRate float64 `json:"download_rate"`
+	UploadRate   float64 `json:"upload_rate"`
+}
+
+// TorrentStats are the live swarm statistics of an active torrent. Rates are
+// in bytes/s and ETA is in seconds, null when it can't be estimated.
+type TorrentStats struct {
+	ID     string        `json:"id"`
+	Hash   string        `json:"hash"`
+	Name   string        `json:"name"`
+	Status TorrentStatus `json:"status"`
+
+	DownloadRate float64 `json:"download_rate"`
+	UploadRate   float64 `json:"upload_rate"`
+	ETA          *int64  `json:"eta"`
+
+	Peers         []PeerStats `json:"peers"`
+	KnownPeers    int         `json:"known_peers"`
+	PendingPeers  int         `json:"pending_peers"`
+	HalfOpenPeers int         `json:"half_open_peers"`
+	Seeders       int         `json:"seeders"`
+	Leechers      int         `json:"leechers"`
+
+	Trackers []TrackerStats `json:"trackers"`
+
+	Pieces          int `json:"pieces"`
+	PiecesCompleted int `json:"pieces_completed"`
+
+	// PieceAvailability is the number of connected peers that have each
+	// piece and DistributedCopies the number of full copies among them.
+	PieceAvailability []int   `json:"piece_availability"`
+	DistributedCopies float64 `json:"distributed_copies"`
+
+	Files []FileStats `json:"files"`
+}
+
+// PeerStats describe a connected peer. The torrent client only reports the
+// data received from a peer, so UploadRate is estimated from the data the peer
+// requested. It is 0 when seeding is disabled.
+type PeerStats struct {
+	Address      string  `json:"address"`
+	Client       string  `json:"client"`
+	Network      string  `json:"network"`
+	Source       string  `json:"source"`
+	Seeder       bool    `json:"seeder"`
+	Progress     int32   `json:"progress"`
+	DownloadRate float64 `json:"download_rate"`
+	UploadRate   float64 `json:"upload_rate"`
+}
+
+// TrackerStats describe a tracker of the announce list. The torrent client
+// keeps the result of its announces private, so neither the announce status
+// nor the errors can be reported.
+type TrackerStats struct {
+	URL  string `json:"url"`
+	Tier int    `json:"tier"`
+}
+
+type FileStats struct {
+	Path           string `json:"path"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytes_completed"`
+	Progress       int32  `json:"progress"`
+	Selected       bool   `json:"selected"`
+}
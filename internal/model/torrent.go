@@ -10,6 +10,12 @@ const (
 	TorrentStatusDownloading TorrentStatus = iota
 	TorrentStatusRendering
 	TorrentStatusReady
+	TorrentStatusFetchingMetadata
+	TorrentStatusFailed
+	TorrentStatusPaused
+	TorrentStatusSelectingFiles
+	TorrentStatusQueued
+	TorrentStatusRenderFailed
 )
 
 type Torrent struct {
@@ -21,13 +27,57 @@ type Torrent struct {
 	AddedTime time.Time     `json:"added_time"`
 	Files     []File        `json:"files"`
 	Poster    NullString    `json:"poster"`
+	Metainfo  []byte        `json:"-"`
+	Failure   NullString    `json:"failure"`
+
+	// QueuePosition orders the queued torrents, the lowest one is started
+	// first.
+	QueuePosition int `json:"queue_position"`
+
+	// SelectFiles is set when the files to download are chosen through the
+	// API instead of the default selection.
+	SelectFiles bool `json:"-"`
+
+	// DownloadLimit in KiB/s overrides the global limit for this torrent
+	// when it is lower. Zero means no override.
+	DownloadLimit int `json:"download_limit"`
+
+	// Seeding is set while the downloaded files are seeded. They are kept on
+	// disk until seeding stops even if rendering finishes earlier.
+	Seeding     bool  `json:"seeding"`
+	SeedingTime int64 `json:"seeding_time"`
+	Uploaded    int64 `json:"uploaded"`
+
+	// Resolutions overrides the configured resolutions when the torrent is
+	// rendered again with different ones. Empty means the configured ones.
+	Resolutions string `json:"resolutions"`
+}
+
+type SeedingProgress struct {
+	ID          string  `json:"id"`
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	Uploaded    int64   `json:"uploaded"`
+	Ratio       float64 `json:"ratio"`
+	SeedingTime int64   `json:"seeding_time"`
+	Peers       int     `json:"peers"`
+	UploadRate  float64 `json:"upload_rate"`
 }
 
 type TorrentProgress struct {
-	ID        string `json:"id"`
-	Hash      string `json:"hash"`
-	Name      string `json:"name"`
-	Progress  int32  `json:"progress"`
-	TotalSize int64  `json:"total_size"`
-	BytesRead int64  `json:"bytes_read"`
+	ID                   string        `json:"id"`
+	Hash                 string        `json:"hash"`
+	Name                 string        `json:"name"`
+	Status               TorrentStatus `json:"status"`
+	Progress             int32         `json:"progress"`
+	TotalSize            int64         `json:"total_size"`
+	BytesRead            int64         `json:"bytes_read"`
+	BytesCompleted       int64         `json:"bytes_completed"`
+	Verifying            bool          `json:"verifying"`
+	VerificationProgress int32         `json:"verification_progress"`
+
+	// DownloadRate in bytes/s is averaged over a short window. ETA is in
+	// seconds, null when it can't be estimated.
+	DownloadRate float64 `json:"download_rate"`
+	ETA          *int64  `json:"eta"`
 }
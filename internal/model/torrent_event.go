@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// TorrentEvent records a status change of a torrent. Message holds the
+// failure reason for failed torrents.
+type TorrentEvent struct {
+	ID        int64         `json:"id"`
+	TorrentID string        `json:"torrent_id"`
+	Status    TorrentStatus `json:"status"`
+	Time      time.Time     `json:"time"`
+	Message   NullString    `json:"message"`
+}
@@ -1,5 +1,11 @@
 package model
 
 type TorrentRequest struct {
-	Magnet string `json:"magnet"`
+	Magnet      string `json:"magnet"`
+	SelectFiles bool   `json:"select_files"`
+}
+
+// RenderRequest renders a torrent again, with the given resolutions if any.
+type RenderRequest struct {
+	Resolutions []string `json:"resolutions"`
 }
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"piflix/internal/hls"
+	"piflix/internal/model"
+	"time"
+)
+
+// renderState tracks a torrent render for the status. Its fields are guarded
+// by the mutex of the HLS manager.
+type renderState struct {
+	torrent *model.Torrent
+	files   []*fileRenderState
+}
+
+// fileRenderState holds a progress tracker for every resolution that was
+// started. The resolutions before done are finished.
+type fileRenderState struct {
+	file        model.File
+	resolutions []string
+	trackers    []*hls.ProgressTracker
+	done        int
+}
+
+// newRenderState takes the resolutions to render for each file because they
+// depend on the size of its video.
+func newRenderState(torrent *model.Torrent, fileIndexes []int, resolutions [][]string) *renderState {
+	state := &renderState{torrent: torrent}
+
+	for i, index := range fileIndexes {
+		state.files = append(state.files, &fileRenderState{
+			file:        torrent.Files[index],
+			resolutions: resolutions[i],
+			trackers:    make([]*hls.ProgressTracker, len(resolutions[i])),
+		})
+	}
+
+	return state
+}
+
+// progress sums up the files. The ETA is only known once every file has
+// started.
+func (rs *renderState) progress() model.RenderProgress {
+	renderProgress := model.RenderProgress{
+		ID:    rs.torrent.ID,
+		Name:  rs.torrent.Name,
+		Files: []model.FileRenderProgress{},
+	}
+
+	var percent int32
+	var timeLeft int64
+	etaKnown := true
+
+	for _, file := range rs.files {
+		fileProgress := file.progress()
+
+		percent += fileProgress.Progress
+		if fileProgress.ETA == nil {
+			etaKnown = false
+		} else {
+			timeLeft += *fileProgress.ETA
+		}
+
+		renderProgress.Files = append(renderProgress.Files, fileProgress)
+	}
+
+	if len(rs.files) > 0 {
+		renderProgress.Progress = percent / int32(len(rs.files))
+	}
+
+	if etaKnown {
+		renderProgress.ETA = &timeLeft
+	}
+
+	return renderProgress
+}
+
+// progress estimates the resolutions that haven't started yet with the
+// duration and the speed of the last started one.
+func (fs *fileRenderState) progress() model.FileRenderProgress {
+	fileProgress := model.FileRenderProgress{
+		ID:          fs.file.ID,
+		Path:        fs.file.Path,
+		Resolutions: []model.ResolutionProgress{},
+	}
+
+	var percent float64
+	var timeLeft time.Duration
+	var last hls.Progress
+	etaKnown := true
+
+	for index, resolution := range fs.resolutions {
+		resolutionProgress := model.ResolutionProgress{Resolution: resolution}
+
+		var progress hls.Progress
+
+		switch {
+		case index < fs.done:
+			if fs.trackers[index] != nil {
+				last = fs.trackers[index].Progress()
+			}
+			progress = hls.Progress{Finished: true}
+		case fs.trackers[index] != nil:
+			progress = fs.trackers[index].Progress()
+			last = progress
+			resolutionProgress.Speed = progress.Speed
+			resolutionProgress.FPS = progress.FPS
+		default:
+			progress = hls.Progress{Duration: last.Duration, Speed: last.Speed}
+		}
+
+		resolutionProgress.Progress = int32(progress.Percent())
+
+		if left, ok := progress.TimeLeft(); ok {
+			resolutionProgress.ETA = durationSeconds(left)
+			timeLeft += left
+		} else {
+			etaKnown = false
+		}
+
+		percent += progress.Percent()
+
+		fileProgress.Resolutions = append(fileProgress.Resolutions, resolutionProgress)
+	}
+
+	if len(fs.resolutions) > 0 {
+		fileProgress.Progress = int32(percent / float64(len(fs.resolutions)))
+	}
+
+	if etaKnown {
+		fileProgress.ETA = durationSeconds(timeLeft)
+	}
+
+	return fileProgress
+}
+
+func durationSeconds(duration time.Duration) *int64 {
+	seconds := int64(duration.Seconds())
+
+	return &seconds
+}
@@ -12,7 +12,8 @@ import (
 )
 
 func setupRoutes(engine *Engine, webFS *embed.FS) {
-	torrentHandler := TorrentHandler{torrentManager: engine.torrentManager, hlsManager: engine.hlsManager, database: engine.database, config: engine.config}
+	torrentHandler := TorrentHandler{torrentManager: engine.torrentManager, hlsManager: engine.hlsManager, database: engine.database, diskMonitor: engine.diskMonitor, config: engine.config}
+	feedHandler := FeedHandler{feedManager: engine.feedManager, database: engine.database}
 	spaFileSystem := utility.EmbedFolder(*webFS, "web/piflix-web/build")
 
 	engine.router.Use(cors.Default())
@@ -20,13 +21,36 @@ func setupRoutes(engine *Engine, webFS *embed.FS) {
 	engine.router.Use(static.Serve("/", spaFileSystem))
 
 	engine.router.POST("/add-torrent", torrentHandler.AddTorrent)
+	engine.router.POST("/add-torrent-file", torrentHandler.AddTorrentFile)
 	engine.router.DELETE("/torrent/:id", torrentHandler.DeleteTorrent)
+	engine.router.POST("/torrent/:id/files", torrentHandler.SelectFiles)
+	engine.router.POST("/torrent/:id/pause", torrentHandler.PauseTorrent)
+	engine.router.POST("/torrent/:id/resume", torrentHandler.ResumeTorrent)
+	engine.router.POST("/torrent/:id/limit", torrentHandler.SetTorrentLimit)
+	engine.router.POST("/torrent/:id/queue-position", torrentHandler.MoveTorrentInQueue)
+	engine.router.POST("/torrent/:id/rerender", torrentHandler.RerenderTorrent)
 	engine.router.GET("/downloaded-torrents", torrentHandler.DownloadedTorrents)
+	engine.router.GET("/queue", torrentHandler.QueuedTorrents)
+	engine.router.GET("/renders", torrentHandler.Renders)
 	engine.router.GET("/torrent/:id", torrentHandler.TorrentByID)
+	engine.router.GET("/torrent/:id/stream/:fileid", torrentHandler.StreamFile)
+	engine.router.GET("/torrent/:id/stats", torrentHandler.TorrentStats)
+	engine.router.GET("/torrent/:id/history", torrentHandler.TorrentHistory)
 	engine.router.GET("/status", torrentHandler.Status)
 	engine.router.POST("/torrent/:id/subtitle/:fileid", torrentHandler.AddSubtitle)
 	engine.router.DELETE("/torrent/:id/subtitle/:fileid", torrentHandler.DeleteSubtitle)
 
+	engine.router.POST("/admin/reload-blocklist", torrentHandler.ReloadBlocklist)
+
+	engine.router.GET("/feeds", feedHandler.Feeds)
+	engine.router.POST("/feeds", feedHandler.AddFeed)
+	engine.router.PUT("/feeds/:id", feedHandler.UpdateFeed)
+	engine.router.DELETE("/feeds/:id", feedHandler.DeleteFeed)
+	engine.router.POST("/feeds/:id/check", feedHandler.CheckFeed)
+	engine.router.POST("/feeds/:id/rules", feedHandler.AddFeedRule)
+	engine.router.PUT("/feeds/:id/rules/:ruleid", feedHandler.UpdateFeedRule)
+	engine.router.DELETE("/feeds/:id/rules/:ruleid", feedHandler.DeleteFeedRule)
+
 	engine.router.Static("/media", fmt.Sprintf("%s/%s", engine.config.WorkDir, "media"))
 
 	engine.router.NoRoute(func(c *gin.Context) {
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// seedState tracks the seeding of a completed torrent. Totals from before the
+// last restart are carried over so the seeding goal survives restarts.
+type seedState struct {
+	since            time.Time
+	previousTime     time.Duration
+	previousUploaded int64
+}
+
+func newSeedState(previousTime time.Duration, previousUploaded int64) *seedState {
+	return &seedState{
+		since:            time.Now(),
+		previousTime:     previousTime,
+		previousUploaded: previousUploaded,
+	}
+}
+
+func (ss *seedState) uploaded(t *torrent.Torrent) int64 {
+	stats := t.Stats()
+	return ss.previousUploaded + stats.BytesWrittenData.Int64()
+}
+
+func (ss *seedState) seedingTime() time.Duration {
+	return ss.previousTime + time.Since(ss.since)
+}
+
+func (ss *seedState) ratio(t *torrent.Torrent, totalSize int64) float64 {
+	if totalSize == 0 {
+		return 0
+	}
+
+	return float64(ss.uploaded(t)) / float64(totalSize)
+}
+
+// goalReached reports whether the torrent was seeded long enough and up to
+// the target ratio.
+func (ss *seedState) goalReached(t *torrent.Torrent, totalSize int64, config *Config) bool {
+	return ss.ratio(t, totalSize) >= config.SeedRatio && ss.seedingTime() >= config.SeedTime
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+// addSeedingTorrent adds a torrent and moves it to the seeding torrents as if
+// it was downloaded.
+func addSeedingTorrent(t *testing.T, tm *TorrentManager, name string) (*ActiveTorrent, bool) {
+	t.Helper()
+
+	data, _ := testTorrentFile(t, name)
+	added, err := tm.AddTorrentFile(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	at := tm.activeTorrents[added.ID]
+	delete(tm.activeTorrents, added.ID)
+
+	if !tm.startSeedingLocked(at) {
+		at.torrent.Drop()
+		return at, false
+	}
+
+	return at, true
+}
+
+func TestSeedStateGoalReached(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	data, _ := testTorrentFile(t, "Movie.mkv")
+	added, err := torrentManager.AddTorrentFile(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrentManager.mutex.Lock()
+	torrent := torrentManager.activeTorrents[added.ID].torrent
+	torrentManager.mutex.Unlock()
+
+	const totalSize = 1000
+
+	tests := []struct {
+		name     string
+		config   Config
+		time     time.Duration
+		uploaded int64
+		reached  bool
+	}{
+		{"nothing uploaded", Config{SeedRatio: 1}, time.Hour, 0, false},
+		{"ratio reached", Config{SeedRatio: 1}, 0, 1000, true},
+		{"ratio reached but not the time", Config{SeedRatio: 1, SeedTime: time.Hour}, time.Minute, 2000, false},
+		{"time reached but not the ratio", Config{SeedRatio: 1, SeedTime: time.Hour}, 2 * time.Hour, 500, false},
+		{"ratio and time reached", Config{SeedRatio: 1, SeedTime: time.Hour}, 2 * time.Hour, 1000, true},
+		{"time only", Config{SeedTime: time.Hour}, 2 * time.Hour, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newSeedState(test.time, test.uploaded)
+
+			if state.goalReached(torrent, totalSize, &test.config) != test.reached {
+				t.Errorf("goal reached is %t with ratio %.2f after %s", !test.reached, state.ratio(torrent, totalSize), state.seedingTime())
+			}
+		})
+	}
+
+	if ratio := newSeedState(0, 1000).ratio(torrent, 0); ratio != 0 {
+		t.Errorf("ratio of a torrent without a size is %.2f, expected 0", ratio)
+	}
+}
+
+func TestStartSeedingRespectsMaxSeedingTorrents(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+	torrentManager.config.Seeding = true
+	torrentManager.config.SeedRatio = 1
+	torrentManager.config.MaxSeedingTorrents = 1
+
+	first, seeding := addSeedingTorrent(t, torrentManager, "First.mkv")
+	if !seeding {
+		t.Fatal("first torrent isn't seeded")
+	}
+
+	second, seeding := addSeedingTorrent(t, torrentManager, "Second.mkv")
+	if seeding {
+		t.Error("second torrent is seeded beyond the maximum")
+	}
+
+	if !torrentManager.database.IsTorrentSeeding(first.ID) {
+		t.Error("first torrent isn't marked as seeding")
+	}
+
+	if torrentManager.database.IsTorrentSeeding(second.ID) {
+		t.Error("second torrent is marked as seeding")
+	}
+
+	torrentManager.config.Seeding = false
+
+	_, seeding = addSeedingTorrent(t, torrentManager, "Third.mkv")
+	if seeding {
+		t.Error("torrent is seeded with seeding disabled")
+	}
+}
+
+func TestCheckSeedingTorrentsStopsAtGoal(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+	torrentManager.config.Seeding = true
+	torrentManager.config.SeedRatio = 1
+	torrentManager.config.SeedTime = time.Hour
+
+	done, _ := addSeedingTorrent(t, torrentManager, "Done.mkv")
+	pending, _ := addSeedingTorrent(t, torrentManager, "Pending.mkv")
+
+	torrentManager.mutex.Lock()
+	done.seed = newSeedState(2*time.Hour, 2*done.totalSize)
+	pending.seed = newSeedState(2*time.Hour, pending.totalSize/2)
+	torrentManager.mutex.Unlock()
+
+	torrentManager.checkSeedingTorrents()
+
+	torrentManager.mutex.Lock()
+	_, doneSeeding := torrentManager.seedingTorrents[done.ID]
+	_, pendingSeeding := torrentManager.seedingTorrents[pending.ID]
+	torrentManager.mutex.Unlock()
+
+	if doneSeeding || torrentManager.database.IsTorrentSeeding(done.ID) {
+		t.Error("torrent that reached its goal is still seeding")
+	}
+
+	if !pendingSeeding || !torrentManager.database.IsTorrentSeeding(pending.ID) {
+		t.Error("torrent that didn't reach its goal stopped seeding")
+	}
+
+	saved, err := torrentManager.database.TorrentWithID(pending.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved.SeedingTime < int64((2*time.Hour).Seconds()) || saved.Uploaded != pending.totalSize/2 {
+		t.Errorf("seeding stats weren't saved: %d seconds, %d bytes uploaded", saved.SeedingTime, saved.Uploaded)
+	}
+}
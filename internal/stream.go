@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+// streamReadahead is the amount of data after the playback position that is
+// requested before it is read.
+const streamReadahead = 16 * 1024 * 1024
+
+// contextReader stops waiting for pieces when the request is cancelled,
+// e.g. when the player disconnects or seeks to another position.
+type contextReader struct {
+	torrent.Reader
+	ctx context.Context
+}
+
+func (r contextReader) Read(b []byte) (int, error) {
+	return r.Reader.ReadContext(r.ctx, b)
+}
+
+// streamReader calls done once when it is closed, so that the priority of the
+// streamed file can be restored.
+type streamReader struct {
+	torrent.Reader
+	done func()
+	once sync.Once
+}
+
+func (r *streamReader) Close() error {
+	err := r.Reader.Close()
+	r.once.Do(r.done)
+	return err
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	encryptionPreferred = "preferred"
+	encryptionRequired  = "required"
+	encryptionTolerated = "tolerated"
+)
+
+// TorrentConfig holds the network settings of the torrent client.
+type TorrentConfig struct {
+	ListenPort int  `mapstructure:"listen_port"`
+	DHT        bool `mapstructure:"dht"`
+	PEX        bool `mapstructure:"pex"`
+	UTP        bool `mapstructure:"utp"`
+	IPv6       bool `mapstructure:"ipv6"`
+
+	// Encryption is the policy for the header obfuscation of peer
+	// connections: preferred, required or tolerated.
+	Encryption string `mapstructure:"encryption"`
+
+	// Trackers are added to every torrent that is added with a magnet link.
+	Trackers []string `mapstructure:"trackers"`
+
+	// Blocklist is the path of a P2P or eMule format IP blocklist, which can
+	// be gzipped.
+	Blocklist string `mapstructure:"blocklist"`
+}
+
+func (tc *TorrentConfig) validate() error {
+	if tc.ListenPort < 0 || tc.ListenPort > 65535 {
+		return errors.New("torrent listen port must be between 0 and 65535")
+	}
+
+	switch tc.Encryption {
+	case encryptionPreferred, encryptionRequired, encryptionTolerated:
+	default:
+		return fmt.Errorf("unknown torrent encryption policy %q", tc.Encryption)
+	}
+
+	for _, tracker := range tc.Trackers {
+		trackerURL, err := url.Parse(tracker)
+		if err != nil || trackerURL.Host == "" {
+			return fmt.Errorf("invalid tracker %q", tracker)
+		}
+
+		switch trackerURL.Scheme {
+		case "http", "https", "udp", "ws", "wss":
+		default:
+			return fmt.Errorf("unsupported tracker scheme in %q", tracker)
+		}
+	}
+
+	return nil
+}
+
+func (tc *TorrentConfig) apply(cfg *torrent.ClientConfig) {
+	cfg.ListenPort = tc.ListenPort
+	cfg.NoDHT = !tc.DHT
+	cfg.DisablePEX = !tc.PEX
+	cfg.DisableUTP = !tc.UTP
+	cfg.DisableIPv6 = !tc.IPv6
+
+	switch tc.Encryption {
+	case encryptionRequired:
+		cfg.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: true}
+	case encryptionTolerated:
+		cfg.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: false, RequirePreferred: false}
+	default:
+		cfg.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: false}
+	}
+}
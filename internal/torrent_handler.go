@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"database/sql"
 	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,6 +22,7 @@ type TorrentHandler struct {
 	torrentManager *TorrentManager
 	hlsManager     *HLSManager
 	database       *db.SQLite
+	diskMonitor    *diskMonitor
 	config         *Config
 }
 
@@ -29,39 +34,42 @@ func (th *TorrentHandler) AddTorrent(c *gin.Context) {
 		return
 	}
 
-	activeTorrent := th.torrentManager.addTorrentWithMagnet(torrentRequest.Magnet)
-	if activeTorrent == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid magnet"})
+	torrent, err := th.torrentManager.AddMagnet(torrentRequest.Magnet, torrentRequest.SelectFiles)
+	if err != nil {
+		c.JSON(statusForAddError(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	torrent, _ := th.database.TorrentWithHash(activeTorrent.torrent.InfoHash().String())
-	if torrent != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "torrent already added"})
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "OK",
+		"torrent": torrent,
+	})
+}
+
+func (th *TorrentHandler) AddTorrentFile(c *gin.Context) {
+	torrentFile, err := c.FormFile("torrent")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	torrentModel := &model.Torrent{
-		ID:        activeTorrent.ID,
-		Hash:      activeTorrent.torrent.InfoHash().String(),
-		Magnet:    torrentRequest.Magnet,
-		Status:    model.TorrentStatusDownloading,
-		AddedTime: time.Now(),
-		Name:      activeTorrent.torrent.Name(),
-		Files:     convertPathsToFiles(activeTorrent),
+	data, err := readMultipartFile(torrentFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	err := th.database.SaveTorrent(torrentModel)
+	selectFiles, _ := strconv.ParseBool(c.PostForm("select_files"))
+
+	torrent, err := th.torrentManager.AddTorrentFile(data, selectFiles)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't save torrent"})
+		c.JSON(statusForAddError(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	th.torrentManager.DownloadActiveTorrent(activeTorrent)
-
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "OK",
-		"torrent": torrentModel,
+		"torrent": torrent,
 	})
 }
 
@@ -88,7 +96,13 @@ func (th *TorrentHandler) DeleteTorrent(c *gin.Context) {
 	if torrent.Status == model.TorrentStatusReady {
 		targetBasePath := filepath.Join(workDir, "media", torrent.ID)
 		os.RemoveAll(targetBasePath)
+
+		if torrent.Seeding {
+			th.torrentManager.stopAndRemoveTorrentWithID(id)
+			utility.DeleteDownloadedFiles(torrent, workDir)
+		}
 	} else if torrent.Status == model.TorrentStatusRendering {
+		th.torrentManager.stopAndRemoveTorrentWithID(id)
 		th.hlsManager.stopProcessing(torrent.ID)
 		targetBasePath := filepath.Join(workDir, "media", torrent.ID)
 		os.RemoveAll(targetBasePath)
@@ -103,6 +117,218 @@ func (th *TorrentHandler) DeleteTorrent(c *gin.Context) {
 	})
 }
 
+// SelectFiles starts the download of a torrent that was added with file
+// selection enabled. Only the files with the given IDs are downloaded.
+func (th *TorrentHandler) SelectFiles(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	var selectionRequest model.FileSelectionRequest
+
+	if err := c.ShouldBindJSON(&selectionRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(selectionRequest.Files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no files selected"})
+		return
+	}
+
+	torrent, err := th.database.TorrentWithID(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "torrent not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if torrent.Status != model.TorrentStatusSelectingFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errNotSelectingFiles.Error()})
+		return
+	}
+
+	filePaths := []string{}
+	for _, fileID := range selectionRequest.Files {
+		file, err := th.database.FileWithID(fileID)
+		if err != nil || file.TorrentID != torrent.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id"})
+			return
+		}
+
+		filePaths = append(filePaths, file.Path)
+	}
+
+	err = th.torrentManager.SelectFiles(torrent.ID, selectionRequest.Files, filePaths)
+	if err != nil {
+		c.JSON(statusForSelectError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+// StreamFile serves a file of a downloading, seeding or rendering torrent with
+// range requests support so it can be played before the render finishes.
+func (th *TorrentHandler) StreamFile(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("fileid"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := th.database.FileWithID(fileID)
+	if err != nil || file.TorrentID != id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id"})
+		return
+	}
+
+	torrent, err := th.database.TorrentWithID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Downloaded files stay on disk until the render is finished.
+	if torrent.Status == model.TorrentStatusRendering && !th.database.IsTorrentSeeding(id) {
+		c.File(filepath.Join(th.config.WorkDir, "downloads", file.Path))
+		return
+	}
+
+	reader, err := th.torrentManager.StreamFile(id, file.Path)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	defer reader.Close()
+
+	http.ServeContent(c.Writer, c.Request, filepath.Base(file.Path), time.Time{}, contextReader{Reader: reader, ctx: c.Request.Context()})
+}
+
+func (th *TorrentHandler) PauseTorrent(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	err := th.torrentManager.PauseTorrent(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+func (th *TorrentHandler) ResumeTorrent(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	err := th.torrentManager.ResumeTorrent(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+// SetTorrentLimit overrides the global download limit for a single torrent.
+func (th *TorrentHandler) SetTorrentLimit(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	var limitRequest model.TorrentLimitRequest
+
+	if err := c.ShouldBindJSON(&limitRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if limitRequest.DownloadLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit can't be negative"})
+		return
+	}
+
+	err := th.torrentManager.SetDownloadLimit(id, limitRequest.DownloadLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+func (th *TorrentHandler) QueuedTorrents(c *gin.Context) {
+	torrents, err := th.database.GetQueuedTorrents()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "OK",
+		"torrents": torrents,
+	})
+}
+
+// MoveTorrentInQueue changes the position of a queued torrent. Positions start
+// at 1 which is the next torrent to be started.
+func (th *TorrentHandler) MoveTorrentInQueue(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	var positionRequest model.QueuePositionRequest
+
+	if err := c.ShouldBindJSON(&positionRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if positionRequest.Position < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid position"})
+		return
+	}
+
+	err := th.torrentManager.MoveTorrentInQueue(id, positionRequest.Position)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
 func (th *TorrentHandler) DownloadedTorrents(c *gin.Context) {
 	torrents, err := th.database.GetDownloadedTorrents()
 	if err != nil {
@@ -129,12 +355,85 @@ func (th *TorrentHandler) TorrentByID(c *gin.Context) {
 		return
 	}
 
+	for i := range torrent.Files {
+		torrent.Files[i].Media, _ = th.database.MediaInfoForFile(torrent.Files[i].ID)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "OK",
 		"torrent": torrent,
 	})
 }
 
+// RerenderTorrent renders a torrent again while its downloaded files still
+// exist, optionally with different resolutions.
+func (th *TorrentHandler) RerenderTorrent(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	var renderRequest model.RenderRequest
+
+	// The body is optional.
+	if err := c.ShouldBindJSON(&renderRequest); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := th.hlsManager.Rerender(id, renderRequest.Resolutions)
+	if err != nil {
+		c.JSON(statusForRenderError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+	})
+}
+
+// Renders returns the render jobs with the position of the queued ones.
+func (th *TorrentHandler) Renders(c *gin.Context) {
+	jobs, err := th.database.GetRenderJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"jobs":   jobs,
+	})
+}
+
+// TorrentHistory returns the status changes of a torrent with the failure
+// reasons.
+func (th *TorrentHandler) TorrentHistory(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	_, err := th.database.TorrentWithID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "torrent not found"})
+		return
+	}
+
+	events, err := th.database.GetEventsForTorrent(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"events": events,
+	})
+}
+
 func (th *TorrentHandler) Status(c *gin.Context) {
 	renderingTorrents, err := th.database.GetRenderingTorrents()
 	if err != nil {
@@ -143,6 +442,14 @@ func (th *TorrentHandler) Status(c *gin.Context) {
 	}
 
 	downloadingTorrents := th.torrentManager.GetDownloadingTorrentsWithProgress()
+
+	failedTorrents, err := th.database.GetFailedTorrents()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	renderFailedTorrents, err := th.database.GetRenderFailedTorrents()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -151,7 +458,15 @@ func (th *TorrentHandler) Status(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":               "OK",
 		"rendering_torrents":   renderingTorrents,
+		"render_progress":      th.hlsManager.RenderProgress(),
 		"downloading_torrents": downloadingTorrents,
+		"seeding_torrents":     th.torrentManager.GetSeedingTorrentsWithProgress(),
+		"failed_torrents":      failedTorrents,
+		"render_failed":        renderFailedTorrents,
+		"bandwidth_limits":     th.torrentManager.BandwidthLimits(),
+		"transfer_rates":       th.torrentManager.TransferRates(),
+		"disk_space":           th.diskMonitor.Status(),
+		"blocklist":            th.torrentManager.BlocklistStats(),
 	})
 }
 
@@ -235,14 +550,95 @@ func (th *TorrentHandler) DeleteSubtitle(c *gin.Context) {
 	})
 }
 
+func (th *TorrentHandler) TorrentStats(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no id"})
+		return
+	}
+
+	stats, err := th.torrentManager.GetTorrentStats(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"stats":  stats,
+	})
+}
+
+func (th *TorrentHandler) ReloadBlocklist(c *gin.Context) {
+	err := th.torrentManager.ReloadBlocklist()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "OK",
+		"blocklist": th.torrentManager.BlocklistStats(),
+	})
+}
+
 // Helper functions
 
-func convertPathsToFiles(activeTorrent *ActiveTorrent) []model.File {
+func statusForAddError(err error) int {
+	switch {
+	case errors.Is(err, errInvalidMagnet), errors.Is(err, errInvalidTorrentFile):
+		return http.StatusBadRequest
+	case errors.Is(err, errTorrentAlreadyAdded):
+		return http.StatusConflict
+	case errors.Is(err, errNotEnoughDiskSpace):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func statusForSelectError(err error) int {
+	switch {
+	case errors.Is(err, errNotSelectingFiles):
+		return http.StatusBadRequest
+	case errors.Is(err, errNotEnoughDiskSpace):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func statusForRenderError(err error) int {
+	switch {
+	case errors.Is(err, errUnknownPreset):
+		return http.StatusBadRequest
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	case errors.Is(err, errNotRenderable), errors.Is(err, errSourceFilesGone):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func readMultipartFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
+func convertTorrentFiles(activeTorrent *ActiveTorrent) []model.File {
 	files := []model.File{}
 
-	for _, path := range activeTorrent.filePaths {
+	for _, torrentFile := range activeTorrent.torrent.Files() {
 		file := model.File{
-			Path:      path,
+			Path:      torrentFile.Path(),
+			Length:    torrentFile.Length(),
+			Selected:  activeTorrent.isFileSelected(torrentFile),
 			TorrentID: activeTorrent.ID,
 		}
 
@@ -1,91 +1,955 @@
 package internal
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"piflix/internal/db"
 	"piflix/internal/model"
-	"strings"
+	"piflix/internal/utility"
+	"sync"
 	"time"
 
 	logger "github.com/anacrolix/log"
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 )
 
 const fileSizeLimit int64 = 67_108_864
 
+// ActiveTorrent is a torrent that is added to the torrent client. Apart from
+// the ID and the torrent, its fields are guarded by the TorrentManager mutex.
 type ActiveTorrent struct {
-	ID        string
-	torrent   *torrent.Torrent
-	totalSize int64
-	filePaths []string
+	ID             string
+	torrent        *torrent.Torrent
+	status         model.TorrentStatus
+	totalSize      int64
+	filePaths      []string
+	verifying      bool
+	verifiedPieces int
+	throttle       torrentThrottle
+	seed           *seedState
+	watching       bool
+	transfer       transferRate
+
+	// streams counts the open readers per file path.
+	streams map[string]int
+}
+
+// TorrentManager manages all torrent related functionalities like downloads, progress updates, etc..
+// It is used concurrently by the HTTP handlers, the cron jobs and the goroutines
+// that follow the individual torrents. Methods with the Locked suffix expect the
+// mutex to be held by the caller.
+type TorrentManager struct {
+	Client          *torrent.Client
+	mutex           sync.Mutex
+	activeTorrents  map[string]*ActiveTorrent
+	seedingTorrents map[string]*ActiveTorrent
+	database        *db.SQLite
+	config          *Config
+	renderQueue     chan<- string
+	downloadLimiter *rate.Limiter
+	uploadLimiter   *rate.Limiter
+	bandwidthLimits model.BandwidthLimits
+	diskSpaceLow    bool
+	blocklist       *ipBlocklist
+	peerTraffic     *peerTraffic
+
+	// addMutex serializes adding torrents, so that the duplicate check and
+	// saving the torrent can't interleave.
+	addMutex sync.Mutex
+
+	// verifySlot lets only one torrent at a time hash its pieces, resumed
+	// torrents would saturate the CPU and the disk otherwise.
+	verifySlot chan struct{}
+}
+
+func NewTorrentManager(config *Config) *TorrentManager {
+	downloadLimiter := newRateLimiter()
+	uploadLimiter := newRateLimiter()
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.NoUpload = !config.Seeding
+	cfg.Seed = config.Seeding
+	cfg.DataDir = filepath.Join(config.WorkDir, "downloads")
+	cfg.DownloadRateLimiter = downloadLimiter
+	cfg.UploadRateLimiter = uploadLimiter
+	config.Torrent.apply(cfg)
+
+	peerTraffic := newPeerTraffic()
+	peerTraffic.register(cfg)
+
+	var blocklist *ipBlocklist
+	if len(config.Torrent.Blocklist) > 0 {
+		blocklist = &ipBlocklist{}
+		cfg.IPBlocklist = blocklist
+
+		err := blocklist.load(config.Torrent.Blocklist)
+		if err != nil {
+			log.Println("Couldn't load blocklist", config.Torrent.Blocklist, "Error:", err)
+		}
+	}
+
+	fileLogger := logger.StreamLogger{
+		W:   log.Writer(),
+		Fmt: logger.LineFormatter,
+	}
+	cfg.Logger = logger.Logger{LoggerImpl: fileLogger}
+
+	client, err := torrent.NewClient(cfg)
+
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	torrentManager := &TorrentManager{
+		Client:          client,
+		activeTorrents:  map[string]*ActiveTorrent{},
+		seedingTorrents: map[string]*ActiveTorrent{},
+		config:          config,
+		downloadLimiter: downloadLimiter,
+		uploadLimiter:   uploadLimiter,
+		blocklist:       blocklist,
+		peerTraffic:     peerTraffic,
+		verifySlot:      make(chan struct{}, 1),
+	}
+
+	torrentManager.SetBandwidthLimits(config.DownloadLimit, config.UploadLimit)
+
+	return torrentManager
+}
+
+// SetBandwidthLimits sets the global limits in KiB/s. Zero removes the limit.
+func (tm *TorrentManager) SetBandwidthLimits(downloadLimit int, uploadLimit int) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	setRateLimit(tm.downloadLimiter, downloadLimit)
+	setRateLimit(tm.uploadLimiter, uploadLimit)
+
+	tm.bandwidthLimits = model.BandwidthLimits{
+		DownloadLimit: downloadLimit,
+		UploadLimit:   uploadLimit,
+	}
+}
+
+func (tm *TorrentManager) BandwidthLimits() model.BandwidthLimits {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	return tm.bandwidthLimits
+}
+
+// SetDownloadLimit sets the download limit in KiB/s for a single torrent. Zero
+// removes the override and leaves only the global limit.
+func (tm *TorrentManager) SetDownloadLimit(id string, limit int) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	activeTorrent, ok := tm.activeTorrents[id]
+	if !ok {
+		return errors.New("torrent is not downloading")
+	}
+
+	err := tm.database.SetDownloadLimitForTorrent(limit, id)
+	if err != nil {
+		return err
+	}
+
+	activeTorrent.throttle.setLimit(limit)
+
+	return nil
+}
+
+// throttleTorrents enforces the per-torrent download limits. It has to be
+// called periodically.
+func (tm *TorrentManager) throttleTorrents() {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	now := time.Now()
+
+	for _, activeTorrent := range tm.activeTorrents {
+		if activeTorrent.status != model.TorrentStatusDownloading {
+			continue
+		}
+
+		activeTorrent.throttle.update(activeTorrent.torrent, now)
+	}
+}
+
+var (
+	errInvalidMagnet       = errors.New("invalid magnet")
+	errInvalidTorrentFile  = errors.New("invalid torrent file")
+	errTorrentAlreadyAdded = errors.New("torrent already added")
+	errNotEnoughDiskSpace  = errors.New("not enough disk space")
+	errNotSelectingFiles   = errors.New("torrent is not waiting for file selection")
+)
+
+// AddMagnet adds a torrent from a magnet link and starts fetching its
+// metadata.
+func (tm *TorrentManager) AddMagnet(magnetURI string, selectFiles bool) (*model.Torrent, error) {
+	magnet, err := metainfo.ParseMagnetUri(magnetURI)
+	if err != nil {
+		return nil, errInvalidMagnet
+	}
+
+	tm.addMutex.Lock()
+	defer tm.addMutex.Unlock()
+
+	err = tm.checkCanAdd(magnet.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	activeTorrent, err := tm.addTorrentWithMagnet(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+
+	return tm.saveAndFetchMetadata(activeTorrent, magnetURI, nil, selectFiles)
+}
+
+// AddTorrentFile adds a torrent from the contents of a .torrent file.
+func (tm *TorrentManager) AddTorrentFile(data []byte, selectFiles bool) (*model.Torrent, error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidTorrentFile
+	}
+
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, errInvalidTorrentFile
+	}
+
+	tm.addMutex.Lock()
+	defer tm.addMutex.Unlock()
+
+	// Check for duplicates before the torrent is handed to the client so that
+	// nothing starts downloading for an already added torrent.
+	infoHash := mi.HashInfoBytes()
+	err = tm.checkCanAdd(infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	activeTorrent, err := tm.addTorrentWithMetainfo(mi)
+	if err != nil {
+		return nil, err
+	}
+
+	// The size is already known, so the torrent is rejected right away if
+	// it doesn't fit on the disk.
+	if !selectFiles {
+		err = tm.checkDiskSpace(activeTorrent)
+		if err != nil {
+			activeTorrent.torrent.Drop()
+			return nil, err
+		}
+	}
+
+	return tm.saveAndFetchMetadata(activeTorrent, mi.Magnet(info.Name, infoHash).String(), data, selectFiles)
+}
+
+// checkCanAdd has to be called with the addMutex held. A torrent that failed
+// before is replaced, so that it can be added again.
+func (tm *TorrentManager) checkCanAdd(infoHash metainfo.Hash) error {
+	torrent, _ := tm.database.TorrentWithHash(infoHash.String())
+	if torrent != nil && torrent.Status != model.TorrentStatusFailed {
+		return errTorrentAlreadyAdded
+	}
+
+	tm.mutex.Lock()
+	diskSpaceLow := tm.diskSpaceLow
+	tm.mutex.Unlock()
+
+	if diskSpaceLow {
+		return fmt.Errorf("%w, downloads are paused until it is freed", errNotEnoughDiskSpace)
+	}
+
+	if torrent != nil {
+		log.Println("Replacing failed torrent", torrent.ID)
+
+		err := tm.database.DeleteTorrent(torrent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tm *TorrentManager) saveAndFetchMetadata(activeTorrent *ActiveTorrent, magnet string, rawMetainfo []byte, selectFiles bool) (*model.Torrent, error) {
+	torrentModel := &model.Torrent{
+		ID:          activeTorrent.ID,
+		Hash:        activeTorrent.torrent.InfoHash().String(),
+		Magnet:      magnet,
+		Status:      model.TorrentStatusFetchingMetadata,
+		AddedTime:   time.Now(),
+		Name:        activeTorrent.torrent.Name(),
+		Files:       []model.File{},
+		Metainfo:    rawMetainfo,
+		SelectFiles: selectFiles,
+	}
+
+	err := tm.database.SaveTorrent(torrentModel)
+	if err != nil {
+		log.Println("Couldn't save torrent", activeTorrent.ID, "Error:", err)
+		activeTorrent.torrent.Drop()
+		return nil, errors.New("couldn't save torrent")
+	}
+
+	tm.FetchMetadata(activeTorrent, selectFiles)
+
+	return torrentModel, nil
+}
+
+func (tm *TorrentManager) addTorrentWithMagnet(magnet string) (*ActiveTorrent, error) {
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnet)
+	if err != nil {
+		return nil, errInvalidMagnet
+	}
+
+	at, err := tm.addTorrentSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tm.config.Torrent.Trackers) > 0 {
+		at.torrent.AddTrackers([][]string{tm.config.Torrent.Trackers})
+	}
+
+	return at, nil
+}
+
+func (tm *TorrentManager) addTorrentWithMetainfo(mi *metainfo.MetaInfo) (*ActiveTorrent, error) {
+	return tm.addTorrentSpec(torrent.TorrentSpecFromMetaInfo(mi))
+}
+
+// addTorrentSpec refuses torrents that the client already has, as they would
+// share the *torrent.Torrent of another ActiveTorrent.
+func (tm *TorrentManager) addTorrentSpec(spec *torrent.TorrentSpec) (*ActiveTorrent, error) {
+	t, new, err := tm.Client.AddTorrentSpec(spec)
+	if err != nil {
+		log.Println("Couldn't add torrent", spec.InfoHash, "to the client. Error:", err)
+		return nil, errors.New("couldn't add torrent")
+	}
+
+	if !new {
+		return nil, errTorrentAlreadyAdded
+	}
+
+	return newActiveTorrent(t), nil
+}
+
+// addTorrentFromModel re-adds a persisted torrent to the client. Torrents added
+// from a .torrent file are restored from their stored metainfo, the rest from
+// their magnet link.
+func (tm *TorrentManager) addTorrentFromModel(torrent *model.Torrent) *ActiveTorrent {
+	var at *ActiveTorrent
+	var err error
+
+	if len(torrent.Metainfo) == 0 {
+		at, err = tm.addTorrentWithMagnet(torrent.Magnet)
+	} else {
+		var mi *metainfo.MetaInfo
+		mi, err = metainfo.Load(bytes.NewReader(torrent.Metainfo))
+		if err == nil {
+			at, err = tm.addTorrentWithMetainfo(mi)
+		}
+	}
+
+	if err != nil {
+		log.Println("Couldn't restore torrent", torrent.ID, "Error:", err)
+		return nil
+	}
+
+	return at
+}
+
+// FetchMetadata resolves the torrent metadata in the background without
+// blocking the caller. When the metadata arrives the torrent files are
+// persisted and the download starts, or if selectFiles is set, the torrent
+// waits for the files to be chosen with SelectFiles. If the metadata doesn't
+// arrive within the configured timeout the torrent is dropped and marked as
+// failed.
+func (tm *TorrentManager) FetchMetadata(activeTorrent *ActiveTorrent, selectFiles bool) {
+	tm.mutex.Lock()
+	tm.activeTorrents[activeTorrent.ID] = activeTorrent
+	tm.mutex.Unlock()
+
+	go func() {
+		err := waitForInfo(activeTorrent.torrent, tm.config.MetadataTimeout)
+
+		tm.mutex.Lock()
+		defer tm.mutex.Unlock()
+
+		// The torrent was deleted while waiting.
+		if tm.activeTorrents[activeTorrent.ID] != activeTorrent {
+			return
+		}
+
+		if err != nil {
+			log.Println("Metadata for torrent", activeTorrent.ID, "not resolved:", err)
+			tm.failTorrentLocked(activeTorrent, err.Error())
+			return
+		}
+
+		setInfoForActiveTorrent(activeTorrent)
+
+		err = tm.database.SetInfoForTorrent(activeTorrent.torrent.Name(), convertTorrentFiles(activeTorrent), activeTorrent.ID)
+		if err != nil {
+			tm.failTorrentLocked(activeTorrent, "couldn't save torrent info")
+			return
+		}
+
+		if !selectFiles {
+			err = tm.checkDiskSpaceLocked(activeTorrent)
+			if err != nil {
+				log.Println("Torrent", activeTorrent.ID, "not started:", err)
+				tm.failTorrentLocked(activeTorrent, err.Error())
+				return
+			}
+		}
+
+		if selectFiles {
+			activeTorrent.status = model.TorrentStatusSelectingFiles
+			tm.database.SetStatusForTorrent(model.TorrentStatusSelectingFiles, activeTorrent.ID)
+			return
+		}
+
+		tm.startOrQueueLocked(activeTorrent)
+	}()
+}
+
+// SelectFiles persists the chosen files and starts the download of a torrent
+// that is waiting for its files to be chosen. Nothing changes if the torrent
+// can't be started with the selection.
+func (tm *TorrentManager) SelectFiles(id string, fileIDs []int64, filePaths []string) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	activeTorrent, ok := tm.activeTorrents[id]
+	if !ok || activeTorrent.status != model.TorrentStatusSelectingFiles {
+		return errNotSelectingFiles
+	}
+
+	previousPaths, previousSize := activeTorrent.filePaths, activeTorrent.totalSize
+
+	activeTorrent.filePaths = filePaths
+	activeTorrent.totalSize = calculateSize(activeTorrent)
+
+	err := tm.checkDiskSpaceLocked(activeTorrent)
+	if err == nil {
+		err = tm.database.SetSelectedFilesForTorrent(fileIDs, id)
+	}
+
+	if err != nil {
+		activeTorrent.filePaths, activeTorrent.totalSize = previousPaths, previousSize
+		return err
+	}
+
+	return tm.startOrQueueLocked(activeTorrent)
+}
+
+// checkDiskSpace checks if there is enough free space to download and render
+// the default file selection of a torrent whose info is already known.
+func (tm *TorrentManager) checkDiskSpace(activeTorrent *ActiveTorrent) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	setInfoForActiveTorrent(activeTorrent)
+
+	return tm.checkDiskSpaceLocked(activeTorrent)
+}
+
+// checkDiskSpaceLocked compares the free space in the working directory with
+// the space that the torrent and all other unfinished torrents still need.
+func (tm *TorrentManager) checkDiskSpaceLocked(activeTorrent *ActiveTorrent) error {
+	free, err := utility.FreeSpace(tm.config.WorkDir)
+	if err != nil {
+		log.Println("Couldn't check free disk space:", err)
+		return nil
+	}
+
+	needed := tm.requiredDiskSpace(activeTorrent)
+	reserved := int64(tm.config.MinFreeSpace) * mebibyte
+
+	for _, at := range tm.activeTorrents {
+		if at == activeTorrent || at.torrent.Info() == nil {
+			continue
+		}
+
+		reserved += tm.requiredDiskSpace(at)
+	}
+
+	if free < needed+reserved {
+		return fmt.Errorf("%w: %d MiB needed, %d MiB free", errNotEnoughDiskSpace, (needed+reserved)/mebibyte, free/mebibyte)
+	}
+
+	return nil
+}
+
+func (tm *TorrentManager) requiredDiskSpace(at *ActiveTorrent) int64 {
+	return at.totalSize - calculateCompletedSize(at) + estimatedRenderSize(at.totalSize, tm.config.Resolutions)
+}
+
+// sampleTransferRates records the transferred data of the active torrents and
+// peers for the rate estimates.
+func (tm *TorrentManager) sampleTransferRates() {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	now := time.Now()
+
+	for _, torrents := range []map[string]*ActiveTorrent{tm.activeTorrents, tm.seedingTorrents} {
+		for _, at := range torrents {
+			stats := at.torrent.Stats()
+			at.transfer.add(now, stats.BytesReadData.Int64(), stats.BytesWrittenData.Int64())
+		}
+	}
+
+	tm.peerTraffic.sample(now)
+}
+
+// TransferRates sums up the rates of the downloading and seeding torrents.
+func (tm *TorrentManager) TransferRates() model.TransferRates {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	rates := model.TransferRates{}
+
+	for _, torrents := range []map[string]*ActiveTorrent{tm.activeTorrents, tm.seedingTorrents} {
+		for _, at := range torrents {
+			download, upload := at.transfer.rates()
+			rates.DownloadRate += download
+			rates.UploadRate += upload
+		}
+	}
+
+	return rates
+}
+
+// GetTorrentStats returns the swarm statistics of a downloading or seeding
+// torrent.
+func (tm *TorrentManager) GetTorrentStats(id string) (*model.TorrentStats, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	var status model.TorrentStatus
+
+	at, ok := tm.activeTorrents[id]
+	if ok {
+		status = at.status
+	} else if at, ok = tm.seedingTorrents[id]; ok {
+		// The status of a seeding torrent is changed by the renderer.
+		torrent, err := tm.database.TorrentWithID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		status = torrent.Status
+	}
+
+	if !ok {
+		return nil, errors.New("torrent is not active")
+	}
+
+	t := at.torrent
+	stats := t.Stats()
+	downloadRate, uploadRate := at.transfer.rates()
+
+	torrentStats := &model.TorrentStats{
+		ID:            at.ID,
+		Hash:          t.InfoHash().String(),
+		Name:          t.Name(),
+		Status:        status,
+		DownloadRate:  downloadRate,
+		UploadRate:    uploadRate,
+		Peers:         []model.PeerStats{},
+		KnownPeers:    stats.TotalPeers,
+		PendingPeers:  stats.PendingPeers,
+		HalfOpenPeers: stats.HalfOpenPeers,
+		Trackers:      []model.TrackerStats{},
+		Files:         []model.FileStats{},
+	}
+
+	if t.Info() != nil {
+		torrentStats.Pieces = t.NumPieces()
+	}
+
+	for tier, urls := range t.Metainfo().AnnounceList {
+		for _, url := range urls {
+			torrentStats.Trackers = append(torrentStats.Trackers, model.TrackerStats{URL: url, Tier: tier})
+		}
+	}
+
+	torrentStats.PieceAvailability = make([]int, torrentStats.Pieces)
+
+	for _, peerConn := range t.PeerConns() {
+		pieces := peerConn.PeerPieces()
+		pieces.IterTyped(func(i int) bool {
+			if i < torrentStats.Pieces {
+				torrentStats.PieceAvailability[i]++
+			}
+
+			return true
+		})
+
+		// Network, Discovery and RemoteAddr are set before the connection is
+		// added to the torrent, the client name is changed under the client
+		// lock later so it is taken from the handshake callback instead.
+		peerStats := model.PeerStats{
+			Client:  tm.peerTraffic.clientName(&peerConn.Peer),
+			Network: peerConn.Network,
+			Source:  string(peerConn.Discovery),
+			Seeder:  torrentStats.Pieces > 0 && pieces.Len() >= torrentStats.Pieces,
+		}
+		peerStats.DownloadRate, peerStats.UploadRate = tm.peerTraffic.rates(&peerConn.Peer)
+
+		if peerConn.RemoteAddr != nil {
+			peerStats.Address = peerConn.RemoteAddr.String()
+		}
+
+		if torrentStats.Pieces > 0 {
+			peerStats.Progress = int32(pieces.Len() * 100 / torrentStats.Pieces)
+		}
+
+		if peerStats.Seeder {
+			torrentStats.Seeders++
+		} else {
+			torrentStats.Leechers++
+		}
+
+		torrentStats.Peers = append(torrentStats.Peers, peerStats)
+	}
+
+	if torrentStats.Pieces == 0 {
+		return torrentStats, nil
+	}
+
+	minAvailability := torrentStats.PieceAvailability[0]
+	for i, availability := range torrentStats.PieceAvailability {
+		if t.PieceState(i).Complete {
+			torrentStats.PiecesCompleted++
+		}
+
+		if availability < minAvailability {
+			minAvailability = availability
+		}
+	}
+
+	aboveMin := 0
+	for _, availability := range torrentStats.PieceAvailability {
+		if availability > minAvailability {
+			aboveMin++
+		}
+	}
+
+	torrentStats.DistributedCopies = float64(minAvailability) + float64(aboveMin)/float64(torrentStats.Pieces)
+
+	for _, file := range t.Files() {
+		fileStats := model.FileStats{
+			Path:           file.Path(),
+			Length:         file.Length(),
+			BytesCompleted: file.BytesCompleted(),
+			Selected:       at.isFileSelected(file),
+		}
+
+		if fileStats.Length > 0 {
+			fileStats.Progress = int32(fileStats.BytesCompleted * 100 / fileStats.Length)
+		}
+
+		torrentStats.Files = append(torrentStats.Files, fileStats)
+	}
+
+	if at.status == model.TorrentStatusDownloading {
+		torrentStats.ETA = estimatedTimeLeft(at.totalSize-calculateCompletedSize(at), downloadRate)
+	}
+
+	return torrentStats, nil
+}
+
+// ReloadBlocklist loads the configured blocklist file again. The ranges that
+// were loaded before are kept if it fails.
+func (tm *TorrentManager) ReloadBlocklist() error {
+	if tm.blocklist == nil {
+		return errors.New("no blocklist configured")
+	}
+
+	return tm.blocklist.load(tm.config.Torrent.Blocklist)
+}
+
+// BlocklistStats returns nil when no blocklist is configured.
+func (tm *TorrentManager) BlocklistStats() *model.BlocklistStats {
+	if tm.blocklist == nil {
+		return nil
+	}
+
+	stats := tm.blocklist.Stats()
+
+	return &stats
+}
+
+// SetDiskSpaceLow stops all downloads while there isn't enough free disk
+// space and starts them again once the space is freed.
+func (tm *TorrentManager) SetDiskSpaceLow(low bool) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.diskSpaceLow = low
+
+	for _, at := range tm.activeTorrents {
+		if at.status != model.TorrentStatusDownloading {
+			continue
+		}
+
+		if low {
+			stopDownloading(at.torrent)
+		} else {
+			startDownloading(at)
+		}
+	}
+}
+
+// startOrQueueLocked starts the download if there is a free download slot,
+// otherwise the torrent is put at the end of the download queue.
+func (tm *TorrentManager) startOrQueueLocked(activeTorrent *ActiveTorrent) error {
+	if tm.hasFreeDownloadSlotLocked() {
+		err := tm.database.SetStatusForTorrent(model.TorrentStatusDownloading, activeTorrent.ID)
+		if err != nil {
+			return err
+		}
+
+		tm.downloadActiveTorrentLocked(activeTorrent)
+		return nil
+	}
+
+	err := tm.database.EnqueueTorrent(activeTorrent.ID)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Torrent", activeTorrent.ID, "is queued.")
+	activeTorrent.status = model.TorrentStatusQueued
+
+	return nil
+}
+
+func (tm *TorrentManager) hasFreeDownloadSlotLocked() bool {
+	if tm.config.MaxActiveDownloads == 0 {
+		return true
+	}
+
+	downloading := 0
+	for _, activeTorrent := range tm.activeTorrents {
+		if activeTorrent.status == model.TorrentStatusDownloading {
+			downloading++
+		}
+	}
+
+	return downloading < tm.config.MaxActiveDownloads
+}
+
+// promoteQueuedTorrentsLocked starts the queued torrents in queue order for as
+// long as there are free download slots.
+func (tm *TorrentManager) promoteQueuedTorrentsLocked() {
+	if !tm.hasFreeDownloadSlotLocked() {
+		return
+	}
+
+	queuedTorrents, err := tm.database.GetQueuedTorrents()
+	if err != nil {
+		log.Println("Couldn't load queued torrents. Error:", err)
+		return
+	}
+
+	for _, queuedTorrent := range queuedTorrents {
+		if !tm.hasFreeDownloadSlotLocked() {
+			return
+		}
+
+		activeTorrent, ok := tm.activeTorrents[queuedTorrent.ID]
+		if !ok || activeTorrent.status != model.TorrentStatusQueued {
+			continue
+		}
+
+		err := tm.database.SetStatusForTorrent(model.TorrentStatusDownloading, activeTorrent.ID)
+		if err != nil {
+			log.Println("Couldn't start queued torrent", activeTorrent.ID, "Error:", err)
+			continue
+		}
+
+		log.Println("Starting queued torrent", activeTorrent.ID)
+		tm.downloadActiveTorrentLocked(activeTorrent)
+	}
 }
 
-// TorrentManager manages all torrent related functionalities like downloads, progress updates, etc..
-type TorrentManager struct {
-	Client         *torrent.Client
-	activeTorrents map[string]*ActiveTorrent
-	database       *db.SQLite
-	config         *Config
+func (tm *TorrentManager) MoveTorrentInQueue(id string, position int) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	activeTorrent, ok := tm.activeTorrents[id]
+	if !ok || activeTorrent.status != model.TorrentStatusQueued {
+		return errors.New("torrent is not queued")
+	}
+
+	return tm.database.MoveTorrentInQueue(id, position)
 }
 
-func NewTorrentManager(config *Config) *TorrentManager {
-	cfg := torrent.NewDefaultClientConfig()
-	cfg.NoUpload = true
-	cfg.DataDir = filepath.Join(config.WorkDir, "downloads")
+func (tm *TorrentManager) downloadActiveTorrentLocked(activeTorrent *ActiveTorrent) {
+	activeTorrent.status = model.TorrentStatusDownloading
+	tm.activeTorrents[activeTorrent.ID] = activeTorrent
 
-	fileLogger := logger.StreamLogger{
-		W:   log.Writer(),
-		Fmt: logger.LineFormatter,
+	if !tm.diskSpaceLow {
+		startDownloading(activeTorrent)
 	}
-	cfg.Logger = logger.Logger{LoggerImpl: fileLogger}
 
-	client, err := torrent.NewClient(cfg)
+	if !activeTorrent.watching {
+		activeTorrent.watching = true
+		go tm.watchCompletion(activeTorrent)
+	}
+}
 
-	if err != nil {
-		log.Println(err)
-		return nil
+// StreamFile returns a reader for a file of a downloading or seeding torrent.
+// The file is prioritized over the other files and the reader requests the
+// pieces sequentially from the playback position, so the file can be watched
+// while the rest of the torrent is still downloading. Paused torrents and
+// downloads stopped for low disk space can't be streamed, as that would
+// download them anyway. The priority is restored when the reader is closed.
+func (tm *TorrentManager) StreamFile(id string, path string) (torrent.Reader, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	activeTorrent, ok := tm.activeTorrents[id]
+	if ok && activeTorrent.status != model.TorrentStatusDownloading {
+		return nil, errors.New("torrent is not downloading")
+	}
+
+	if ok && tm.diskSpaceLow {
+		return nil, errors.New("downloads are stopped because the disk is almost full")
+	}
+
+	if !ok {
+		activeTorrent, ok = tm.seedingTorrents[id]
+	}
+
+	if !ok || activeTorrent.torrent.Info() == nil {
+		return nil, errors.New("torrent is not active")
+	}
+
+	for _, file := range activeTorrent.torrent.Files() {
+		if file.Path() != path || !activeTorrent.isFileSelected(file) {
+			continue
+		}
+
+		if activeTorrent.streams == nil {
+			activeTorrent.streams = map[string]int{}
+		}
+		activeTorrent.streams[path]++
+
+		file.SetPriority(torrent.PiecePriorityHigh)
+
+		reader := file.NewReader()
+		reader.SetReadahead(streamReadahead)
+		reader.SetResponsive()
+
+		return &streamReader{Reader: reader, done: func() { tm.closeStream(activeTorrent, file) }}, nil
+	}
+
+	return nil, errors.New("file is not selected for download")
+}
+
+// closeStream gives a streamed file the priority of the other selected files
+// again once its last reader is closed.
+func (tm *TorrentManager) closeStream(at *ActiveTorrent, file *torrent.File) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	at.streams[file.Path()]--
+	if at.streams[file.Path()] > 0 {
+		return
 	}
 
-	return &TorrentManager{
-		Client:         client,
-		activeTorrents: map[string]*ActiveTorrent{},
-		config:         config,
+	delete(at.streams, file.Path())
+
+	select {
+	case <-at.torrent.Closed():
+		return
+	default:
+	}
+
+	// The download was paused or stopped while the file was streamed.
+	if tm.activeTorrents[at.ID] == at && (at.status != model.TorrentStatusDownloading || tm.diskSpaceLow) {
+		file.SetPriority(torrent.PiecePriorityNone)
+		return
 	}
+
+	file.SetPriority(torrent.PiecePriorityNormal)
 }
 
-func (tm *TorrentManager) addTorrentWithMagnet(magnet string) *ActiveTorrent {
-	t, err := tm.Client.AddMagnet(magnet)
+// PauseTorrent stops requesting data for the torrent. Pieces that were already
+// verified are kept so the download continues where it stopped once resumed.
+func (tm *TorrentManager) PauseTorrent(id string) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	activeTorrent, ok := tm.activeTorrents[id]
+	if !ok || activeTorrent.status != model.TorrentStatusDownloading {
+		return errors.New("torrent is not downloading")
+	}
 
+	err := tm.database.SetStatusForTorrent(model.TorrentStatusPaused, id)
 	if err != nil {
-		log.Println(err)
-		return nil
+		return err
 	}
 
-	// Wait for torrent info to download.
-	<-t.GotInfo()
+	activeTorrent.status = model.TorrentStatusPaused
+	stopDownloading(activeTorrent.torrent)
 
-	activeTorrent := &ActiveTorrent{
-		ID:        uuid.NewString(),
-		torrent:   t,
-		totalSize: calculateSize(t),
-		filePaths: getValidFilepaths(t),
+	tm.promoteQueuedTorrentsLocked()
+
+	return nil
+}
+
+func (tm *TorrentManager) ResumeTorrent(id string) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	activeTorrent, ok := tm.activeTorrents[id]
+	if !ok || activeTorrent.status != model.TorrentStatusPaused {
+		return errors.New("torrent is not paused")
 	}
 
-	return activeTorrent
+	return tm.startOrQueueLocked(activeTorrent)
 }
 
-func (tm *TorrentManager) DownloadActiveTorrent(activeTorrent *ActiveTorrent) {
-	tm.activeTorrents[activeTorrent.ID] = activeTorrent
+func (tm *TorrentManager) failTorrentLocked(activeTorrent *ActiveTorrent, reason string) {
+	activeTorrent.torrent.Drop()
+	delete(tm.activeTorrents, activeTorrent.ID)
 
-	startDownloading(activeTorrent.torrent)
+	err := tm.database.SetFailureForTorrent(reason, activeTorrent.ID)
+	if err != nil {
+		log.Println("Couldn't mark torrent", activeTorrent.ID, "as failed. Error:", err)
+	}
 }
 
 func (tm *TorrentManager) stopAndRemoveTorrentWithID(id string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if activeTorrent, ok := tm.seedingTorrents[id]; ok {
+		activeTorrent.torrent.Drop()
+		delete(tm.seedingTorrents, id)
+	}
+
 	activeTorrent, ok := tm.activeTorrents[id]
 	if !ok {
 		return
@@ -93,40 +957,285 @@ func (tm *TorrentManager) stopAndRemoveTorrentWithID(id string) {
 
 	activeTorrent.torrent.Drop()
 	delete(tm.activeTorrents, id)
+
+	tm.promoteQueuedTorrentsLocked()
 }
 
 func (tm *TorrentManager) resumeTorrents(torrents []model.Torrent) {
 	for _, torrent := range torrents {
-		at := tm.addTorrentWithMagnet(torrent.Magnet)
+		at := tm.addTorrentFromModel(&torrent)
+		if at == nil {
+			continue
+		}
 
 		// Override generated random ID because we are resuming the torrent.
 		at.ID = torrent.ID
+		at.throttle.setLimit(torrent.DownloadLimit)
+
+		if torrent.Status == model.TorrentStatusFetchingMetadata {
+			tm.FetchMetadata(at, torrent.SelectFiles)
+			continue
+		}
+
+		at.filePaths = selectedFilePaths(torrent.Files)
+
+		tm.mutex.Lock()
+		tm.activeTorrents[at.ID] = at
+		tm.mutex.Unlock()
+
+		go tm.resumeDownload(at, torrent.Status)
+	}
+}
+
+// resumeDownload continues a download from the data that is already on disk.
+// Every piece is verified first so that only the missing and corrupted pieces
+// are downloaded again.
+func (tm *TorrentManager) resumeDownload(at *ActiveTorrent, status model.TorrentStatus) {
+	// The metadata was already resolved once so keep waiting for it without
+	// a timeout.
+	err := waitForInfo(at.torrent, 0)
+	if err != nil {
+		return
+	}
+
+	tm.mutex.Lock()
+	setInfoForActiveTorrent(at)
+	at.status = status
+	tm.mutex.Unlock()
+
+	tm.verifyData(at)
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	// The torrent was deleted during verification.
+	if tm.activeTorrents[at.ID] != at {
+		return
+	}
+
+	if at.status == model.TorrentStatusQueued {
+		tm.promoteQueuedTorrentsLocked()
+		return
+	}
+
+	if at.status != model.TorrentStatusDownloading {
+		return
+	}
+
+	tm.downloadActiveTorrentLocked(at)
+}
+
+// verifyData hashes the pieces one by one so the verification progress can be
+// reported while it runs. The torrent is shown as verifying while it waits for
+// the other torrents to be verified.
+func (tm *TorrentManager) verifyData(at *ActiveTorrent) {
+	tm.mutex.Lock()
+	at.verifiedPieces = 0
+	at.verifying = true
+	tm.mutex.Unlock()
+
+	select {
+	case tm.verifySlot <- struct{}{}:
+		defer func() { <-tm.verifySlot }()
+	case <-at.torrent.Closed():
+		return
+	}
+
+	for i := 0; i < at.torrent.NumPieces(); i++ {
+		select {
+		case <-at.torrent.Closed():
+			return
+		default:
+		}
+
+		at.torrent.Piece(i).VerifyData()
+
+		tm.mutex.Lock()
+		at.verifiedPieces = i + 1
+		tm.mutex.Unlock()
+	}
+
+	tm.mutex.Lock()
+	at.verifying = false
+	tm.mutex.Unlock()
+}
+
+// watchCompletion waits until all pieces of the selected files are downloaded
+// and verified and then hands the torrent over for rendering.
+func (tm *TorrentManager) watchCompletion(at *ActiveTorrent) {
+	// Subscribe before looking for the missing pieces so that no change
+	// is lost in between.
+	subscription := at.torrent.SubscribePieceStateChanges()
+	defer subscription.Close()
+
+	tm.mutex.Lock()
+	missing := missingPieces(at)
+	tm.mutex.Unlock()
+
+	for len(missing) > 0 {
+		select {
+		case value, ok := <-subscription.Values:
+			if !ok {
+				return
+			}
+
+			change := value.(torrent.PieceStateChange)
+			if missing[change.Index] && isPieceVerified(change.PieceState) {
+				delete(missing, change.Index)
+			}
+		case <-at.torrent.Closed():
+			return
+		}
+	}
+
+	tm.completeTorrent(at)
+}
+
+func (tm *TorrentManager) completeTorrent(at *ActiveTorrent) {
+	tm.mutex.Lock()
+
+	// The torrent was deleted right before it completed.
+	if tm.activeTorrents[at.ID] != at {
+		tm.mutex.Unlock()
+		return
+	}
+
+	log.Println("Torrent", at.ID, "is downloaded.")
+
+	delete(tm.activeTorrents, at.ID)
+
+	if !tm.startSeedingLocked(at) {
+		at.torrent.Drop()
+		checkAndRemoveUnselectedFilesFromDisk(at, tm.config.WorkDir)
+	}
+
+	tm.database.DeleteUnselectedFiles(at.ID)
+	tm.database.SetStatusForTorrent(model.TorrentStatusRendering, at.ID)
+
+	tm.promoteQueuedTorrentsLocked()
+
+	tm.mutex.Unlock()
+
+	go tm.downloadImageForActiveTorrent(at)
+
+	tm.renderQueue <- at.ID
+}
+
+// startSeedingLocked keeps a completed torrent in the client so that it is
+// seeded until the seeding goal is reached. It returns false if the torrent
+// shouldn't be seeded.
+func (tm *TorrentManager) startSeedingLocked(at *ActiveTorrent) bool {
+	if !tm.config.Seeding {
+		return false
+	}
+
+	if tm.config.MaxSeedingTorrents > 0 && len(tm.seedingTorrents) >= tm.config.MaxSeedingTorrents {
+		log.Println("Not seeding torrent", at.ID, "because the maximum number of seeding torrents is reached.")
+		return false
+	}
+
+	err := tm.database.SetSeedingForTorrent(true, at.ID)
+	if err != nil {
+		log.Println("Couldn't start seeding torrent", at.ID, "Error:", err)
+		return false
+	}
+
+	at.seed = newSeedState(0, 0)
+	tm.seedingTorrents[at.ID] = at
+
+	return true
+}
+
+func (tm *TorrentManager) resumeSeeding(torrents []model.Torrent) {
+	for _, torrent := range torrents {
+		at := tm.addTorrentFromModel(&torrent)
+		if at == nil {
+			continue
+		}
+
+		at.ID = torrent.ID
+		at.filePaths = selectedFilePaths(torrent.Files)
+		at.seed = newSeedState(time.Duration(torrent.SeedingTime)*time.Second, torrent.Uploaded)
+
+		tm.mutex.Lock()
+		tm.seedingTorrents[at.ID] = at
+		tm.mutex.Unlock()
 
-		removeAllFilesForTorrent(at, tm.config.WorkDir)
-		at.torrent.VerifyData()
+		go func() {
+			if waitForInfo(at.torrent, 0) != nil {
+				return
+			}
 
-		tm.DownloadActiveTorrent(at)
+			tm.mutex.Lock()
+			setInfoForActiveTorrent(at)
+			tm.mutex.Unlock()
+		}()
 	}
 }
 
-func (tm *TorrentManager) checkForCompletedTorrents(renderChan chan string) {
-	for key, t := range tm.activeTorrents {
-		downloadedBytes := t.torrent.Stats().BytesReadData
-		if t.totalSize <= downloadedBytes.Int64() {
-			delete(tm.activeTorrents, key)
+// checkSeedingTorrents persists the seeding stats and stops seeding the
+// torrents that reached their seeding goal.
+func (tm *TorrentManager) checkSeedingTorrents() {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
 
-			time.AfterFunc(5*time.Second, func() {
-				t.torrent.Drop()
+	for _, at := range tm.seedingTorrents {
+		if at.totalSize == 0 {
+			continue
+		}
+
+		seedingTime := int64(at.seed.seedingTime().Seconds())
+		tm.database.SetSeedingStatsForTorrent(seedingTime, at.seed.uploaded(at.torrent), at.ID)
+
+		if at.seed.goalReached(at.torrent, at.totalSize, tm.config) {
+			log.Println("Torrent", at.ID, "reached its seeding goal.")
+			tm.stopSeedingLocked(at)
+		}
+	}
+}
+
+func (tm *TorrentManager) stopSeedingLocked(at *ActiveTorrent) {
+	at.torrent.Drop()
+	delete(tm.seedingTorrents, at.ID)
 
-				checkAndRemoveInvalidFilesFromDisk(t, tm.config.WorkDir)
-				tm.database.SetStatusForTorrent(model.TorrentStatusRendering, t.ID)
+	checkAndRemoveUnselectedFilesFromDisk(at, tm.config.WorkDir)
+	tm.database.SetSeedingForTorrent(false, at.ID)
 
-				go tm.downloadImageForActiveTorrent(t)
+	// The downloaded files are deleted here only if rendering finished while
+	// the torrent was seeding, otherwise the renderer deletes them. They are
+	// kept after a failed render so that it can be rendered again.
+	torrent, err := tm.database.TorrentWithID(at.ID)
+	if err == nil && (torrent.Status == model.TorrentStatusRendering || torrent.Status == model.TorrentStatusRenderFailed) {
+		return
+	}
+
+	utility.DeleteDownloadedFiles(&model.Torrent{ID: at.ID, Files: convertTorrentFiles(at)}, tm.config.WorkDir)
+}
 
-				renderChan <- t.ID
-			})
+func (tm *TorrentManager) GetSeedingTorrentsWithProgress() []model.SeedingProgress {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	seedingTorrents := []model.SeedingProgress{}
+
+	for _, at := range tm.seedingTorrents {
+		stats := at.torrent.Stats()
+
+		seedingProgress := model.SeedingProgress{
+			ID:          at.ID,
+			Hash:        at.torrent.InfoHash().String(),
+			Name:        at.torrent.Name(),
+			Uploaded:    at.seed.uploaded(at.torrent),
+			Ratio:       at.seed.ratio(at.torrent, at.totalSize),
+			SeedingTime: int64(at.seed.seedingTime().Seconds()),
+			Peers:       stats.ActivePeers,
 		}
+		_, seedingProgress.UploadRate = at.transfer.rates()
+
+		seedingTorrents = append(seedingTorrents, seedingProgress)
 	}
+
+	return seedingTorrents
 }
 
 func (tm *TorrentManager) downloadImageForActiveTorrent(t *ActiveTorrent) {
@@ -143,24 +1252,51 @@ func (tm *TorrentManager) downloadImageForActiveTorrent(t *ActiveTorrent) {
 func (tm *TorrentManager) printStatus() {
 	torrentsWithProgress := tm.GetDownloadingTorrentsWithProgress()
 	for _, torrentProgress := range torrentsWithProgress {
-		log.Printf("Torrent %s: %d%% torrent downloaded. Bytes read %d. Total size %d.", torrentProgress.ID, torrentProgress.Progress, torrentProgress.BytesRead, torrentProgress.TotalSize)
+		if torrentProgress.Verifying {
+			log.Printf("Torrent %s: %d%% torrent verified.", torrentProgress.ID, torrentProgress.VerificationProgress)
+			continue
+		}
+
+		log.Printf("Torrent %s: %d%% torrent downloaded. Bytes read %d. Total size %d. Rate %.1f KiB/s.", torrentProgress.ID, torrentProgress.Progress, torrentProgress.BytesRead, torrentProgress.TotalSize, torrentProgress.DownloadRate/1024)
 	}
 }
 
 func (tm *TorrentManager) GetDownloadingTorrentsWithProgress() []model.TorrentProgress {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
 	torrentsInProgress := []model.TorrentProgress{}
 
 	for _, activeTorrent := range tm.activeTorrents {
 		bytesRead := activeTorrent.torrent.Stats().BytesReadData
-		progress := float64(bytesRead.Int64()) / float64(activeTorrent.totalSize) * 100
+		bytesCompleted := int64(0)
+		progress := float64(0)
+		if activeTorrent.totalSize > 0 {
+			bytesCompleted = calculateCompletedSize(activeTorrent)
+			progress = float64(bytesCompleted) / float64(activeTorrent.totalSize) * 100
+		}
+
+		verificationProgress := float64(0)
+		if activeTorrent.verifying {
+			verificationProgress = float64(activeTorrent.verifiedPieces) / float64(activeTorrent.torrent.NumPieces()) * 100
+		}
 
 		torrentProgress := model.TorrentProgress{
-			ID:        activeTorrent.ID,
-			Hash:      activeTorrent.torrent.InfoHash().String(),
-			Name:      activeTorrent.torrent.Name(),
-			Progress:  int32(math.Min(100, progress)),
-			TotalSize: activeTorrent.totalSize,
-			BytesRead: bytesRead.Int64(),
+			ID:                   activeTorrent.ID,
+			Hash:                 activeTorrent.torrent.InfoHash().String(),
+			Name:                 activeTorrent.torrent.Name(),
+			Status:               activeTorrent.status,
+			Progress:             int32(math.Min(100, progress)),
+			TotalSize:            activeTorrent.totalSize,
+			BytesRead:            bytesRead.Int64(),
+			BytesCompleted:       bytesCompleted,
+			Verifying:            activeTorrent.verifying,
+			VerificationProgress: int32(verificationProgress),
+		}
+
+		torrentProgress.DownloadRate, _ = activeTorrent.transfer.rates()
+		if activeTorrent.status == model.TorrentStatusDownloading && activeTorrent.totalSize > 0 {
+			torrentProgress.ETA = estimatedTimeLeft(activeTorrent.totalSize-bytesCompleted, torrentProgress.DownloadRate)
 		}
 
 		torrentsInProgress = append(torrentsInProgress, torrentProgress)
@@ -171,27 +1307,106 @@ func (tm *TorrentManager) GetDownloadingTorrentsWithProgress() []model.TorrentPr
 
 // Helper functions
 
-func startDownloading(t *torrent.Torrent) {
-	files := t.Files()
+func newActiveTorrent(t *torrent.Torrent) *ActiveTorrent {
+	activeTorrent := &ActiveTorrent{
+		ID:      uuid.NewString(),
+		torrent: t,
+		status:  model.TorrentStatusFetchingMetadata,
+	}
+
+	return activeTorrent
+}
+
+// waitForInfo blocks until the torrent metadata is available. A timeout of
+// zero waits until the torrent is dropped.
+func waitForInfo(t *torrent.Torrent, timeout time.Duration) error {
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case <-t.GotInfo():
+		return nil
+	case <-t.Closed():
+		return errors.New("torrent was dropped")
+	case <-timeoutChan:
+		return fmt.Errorf("metadata not received within %s", timeout)
+	}
+}
+
+// setInfoForActiveTorrent fills in the details that depend on the torrent
+// metadata. Torrents without a persisted file selection get the default one.
+func setInfoForActiveTorrent(at *ActiveTorrent) {
+	if at.filePaths == nil {
+		at.filePaths = defaultFileSelection(at.torrent)
+	}
+
+	at.totalSize = calculateSize(at)
+}
+
+func startDownloading(at *ActiveTorrent) {
+	files := at.torrent.Files()
 	for _, file := range files {
-		if file.Length() < fileSizeLimit {
-			log.Println("Skipping because of small size:", file.Path())
+		if !at.isFileSelected(file) {
+			log.Println("Skipping because file is not selected:", file.Path())
 			file.SetPriority(torrent.PiecePriorityNone)
 			continue
 		}
 
 		log.Println("Downloading:", file.Path())
 
+		if at.streams[file.Path()] > 0 {
+			file.SetPriority(torrent.PiecePriorityHigh)
+			continue
+		}
+
 		file.SetPriority(torrent.PiecePriorityNormal)
 	}
 }
 
-func calculateSize(t *torrent.Torrent) int64 {
+// missingPieces returns the pieces of the selected files that are not
+// downloaded and verified yet.
+func missingPieces(at *ActiveTorrent) map[int]bool {
+	missing := map[int]bool{}
+	pieceLength := at.torrent.Info().PieceLength
+
+	for _, file := range at.torrent.Files() {
+		if !at.isFileSelected(file) || file.Length() == 0 {
+			continue
+		}
+
+		begin := int(file.Offset() / pieceLength)
+		end := int((file.Offset() + file.Length() + pieceLength - 1) / pieceLength)
+
+		for i := begin; i < end; i++ {
+			if !isPieceVerified(at.torrent.PieceState(i)) {
+				missing[i] = true
+			}
+		}
+	}
+
+	return missing
+}
+
+func isPieceVerified(state torrent.PieceState) bool {
+	return state.Complete && !state.Hashing && !state.QueuedForHash && !state.Marking
+}
+
+func stopDownloading(t *torrent.Torrent) {
+	for _, file := range t.Files() {
+		file.SetPriority(torrent.PiecePriorityNone)
+	}
+}
+
+func calculateSize(at *ActiveTorrent) int64 {
 	totalSize := int64(0)
-	files := t.Files()
+	files := at.torrent.Files()
 
 	for _, file := range files {
-		if file.Length() < fileSizeLimit {
+		if !at.isFileSelected(file) {
 			continue
 		}
 
@@ -201,7 +1416,26 @@ func calculateSize(t *torrent.Torrent) int64 {
 	return totalSize
 }
 
-func getValidFilepaths(t *torrent.Torrent) []string {
+// calculateCompletedSize returns the number of bytes of the wanted files that
+// are already downloaded and verified.
+func calculateCompletedSize(at *ActiveTorrent) int64 {
+	completedSize := int64(0)
+	files := at.torrent.Files()
+
+	for _, file := range files {
+		if !at.isFileSelected(file) {
+			continue
+		}
+
+		completedSize += file.BytesCompleted()
+	}
+
+	return completedSize
+}
+
+// defaultFileSelection selects the files that are big enough to be videos.
+// Smaller files are usually subtitles, samples or images.
+func defaultFileSelection(t *torrent.Torrent) []string {
 	filepaths := []string{}
 	files := t.Files()
 
@@ -216,31 +1450,36 @@ func getValidFilepaths(t *torrent.Torrent) []string {
 	return filepaths
 }
 
-func checkAndRemoveInvalidFilesFromDisk(at *ActiveTorrent, workDir string) {
-	files := at.torrent.Files()
+func selectedFilePaths(files []model.File) []string {
+	filePaths := []string{}
 
 	for _, file := range files {
-		if file.Length() >= fileSizeLimit {
-			continue
+		if file.Selected {
+			filePaths = append(filePaths, file.Path)
 		}
+	}
 
-		path := filepath.Join(workDir, "downloads", file.Path())
-
-		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-			continue
-		}
+	return filePaths
+}
 
-		err := os.Remove(path)
-		if err != nil {
-			log.Println("Error when deleting file:", err)
+func (at *ActiveTorrent) isFileSelected(file *torrent.File) bool {
+	for _, path := range at.filePaths {
+		if path == file.Path() {
+			return true
 		}
 	}
+
+	return false
 }
 
-func removeAllFilesForTorrent(at *ActiveTorrent, workDir string) {
+func checkAndRemoveUnselectedFilesFromDisk(at *ActiveTorrent, workDir string) {
 	files := at.torrent.Files()
 
 	for _, file := range files {
+		if at.isFileSelected(file) {
+			continue
+		}
+
 		path := filepath.Join(workDir, "downloads", file.Path())
 
 		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
@@ -251,14 +1490,5 @@ func removeAllFilesForTorrent(at *ActiveTorrent, workDir string) {
 		if err != nil {
 			log.Println("Error when deleting file:", err)
 		}
-
-		pathComponents := strings.Split(file.Path(), "/")
-		if len(pathComponents) == 0 {
-			continue
-		}
-
-		firstDir := pathComponents[0]
-		torrentBasePath := filepath.Join(workDir, "downloads", firstDir)
-		os.RemoveAll(torrentBasePath)
 	}
 }
@@ -0,0 +1,612 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"piflix/internal/model"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// TestTorrentManagerConcurrentAccess adds, inspects and removes torrents from
+// many goroutines while the cron jobs and the status handlers read them. It
+// is meant to be run with the race detector.
+func TestTorrentManagerConcurrentAccess(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	const adders = 8
+	const rounds = 10
+
+	// t.Fatal can't be called from the adding goroutines.
+	torrentFiles := make([][]byte, adders*rounds)
+	for i := range torrentFiles {
+		torrentFiles[i], _ = testTorrentFile(t, fmt.Sprintf("Movie %d.mkv", i))
+	}
+
+	added := make(chan string)
+	stop := make(chan struct{})
+
+	var readers sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				torrentManager.GetDownloadingTorrentsWithProgress()
+				torrentManager.GetSeedingTorrentsWithProgress()
+				torrentManager.TransferRates()
+				torrentManager.sampleTransferRates()
+				torrentManager.throttleTorrents()
+			}
+		}()
+	}
+
+	var removers sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		removers.Add(1)
+		go func() {
+			defer removers.Done()
+
+			for id := range added {
+				torrentManager.GetTorrentStats(id)
+				torrentManager.stopAndRemoveTorrentWithID(id)
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < adders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < rounds; j++ {
+				n := i*rounds + j
+				id := ""
+
+				if j%2 == 0 {
+					magnet := fmt.Sprintf("magnet:?xt=urn:btih:%040x", n+1)
+
+					torrent, err := torrentManager.AddMagnet(magnet, false)
+					if err != nil {
+						t.Error("adding magnet failed:", err)
+						continue
+					}
+
+					id = torrent.ID
+				} else {
+					torrent, err := torrentManager.AddTorrentFile(torrentFiles[n], false)
+					if err != nil {
+						t.Error("adding torrent file failed:", err)
+						continue
+					}
+
+					id = torrent.ID
+				}
+
+				torrentManager.GetTorrentStats(id)
+				added <- id
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(added)
+	removers.Wait()
+	close(stop)
+	readers.Wait()
+
+	if downloading := torrentManager.GetDownloadingTorrentsWithProgress(); len(downloading) != 0 {
+		t.Errorf("%d torrents are still downloading", len(downloading))
+	}
+
+	torrentManager.mutex.Lock()
+	active := len(torrentManager.activeTorrents)
+	torrentManager.mutex.Unlock()
+
+	if active != 0 {
+		t.Errorf("%d torrents are still active", active)
+	}
+}
+
+// TestTorrentManagerRemoveWhileFetchingMetadata removes torrents while the
+// goroutines that wait for their metadata are starting.
+func TestTorrentManagerRemoveWhileFetchingMetadata(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+	torrentManager.config.MetadataTimeout = time.Millisecond
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			torrent, err := torrentManager.AddMagnet(fmt.Sprintf("magnet:?xt=urn:btih:%040x", i+1), false)
+			if err != nil {
+				t.Error("adding magnet failed:", err)
+				return
+			}
+
+			if i%2 == 0 {
+				torrentManager.stopAndRemoveTorrentWithID(torrent.ID)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	// The remaining torrents fail once their metadata timeout is reached.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		torrentManager.mutex.Lock()
+		active := len(torrentManager.activeTorrents)
+		torrentManager.mutex.Unlock()
+
+		if active == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d torrents are still active", active)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestTorrentManagerConcurrentDuplicateAdds adds the same torrent from many
+// goroutines, as a .torrent file and as a magnet link. Only one of them may
+// be added, the others have to share nothing with it.
+func TestTorrentManagerConcurrentDuplicateAdds(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	data, hash := testTorrentFile(t, "Movie.mkv")
+	magnet := "magnet:?xt=urn:btih:" + hash.HexString()
+
+	var added, duplicates int32
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var err error
+			if i%2 == 0 {
+				_, err = torrentManager.AddTorrentFile(data, false)
+			} else {
+				_, err = torrentManager.AddMagnet(magnet, false)
+			}
+
+			switch {
+			case err == nil:
+				atomic.AddInt32(&added, 1)
+			case errors.Is(err, errTorrentAlreadyAdded):
+				atomic.AddInt32(&duplicates, 1)
+			default:
+				t.Error("adding torrent failed:", err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if added != 1 || duplicates != 15 {
+		t.Errorf("%d adds succeeded and %d were duplicates, expected 1 and 15", added, duplicates)
+	}
+
+	torrentManager.mutex.Lock()
+	active := len(torrentManager.activeTorrents)
+	torrentManager.mutex.Unlock()
+
+	if active != 1 {
+		t.Errorf("%d torrents are active for one info hash", active)
+	}
+
+	if torrent, _ := torrentManager.database.TorrentWithHash(hash.String()); torrent == nil {
+		t.Error("torrent wasn't saved")
+	}
+}
+
+func TestTorrentManagerReaddFailedTorrent(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+	torrentManager.config.MetadataTimeout = time.Millisecond
+
+	magnet := fmt.Sprintf("magnet:?xt=urn:btih:%040x", 1)
+
+	failed, err := torrentManager.AddMagnet(magnet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		torrent, err := torrentManager.database.TorrentWithID(failed.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if torrent.Status == model.TorrentStatusFailed {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("metadata timeout didn't fail the torrent")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	torrentManager.config.MetadataTimeout = time.Hour
+
+	added, err := torrentManager.AddMagnet(magnet, false)
+	if err != nil {
+		t.Fatal("adding failed torrent again:", err)
+	}
+
+	if added.ID == failed.ID {
+		t.Error("torrent that was added again kept its ID")
+	}
+
+	if _, err := torrentManager.database.TorrentWithID(failed.ID); err == nil {
+		t.Error("failed torrent wasn't replaced")
+	}
+
+	_, err = torrentManager.AddMagnet(magnet, false)
+	if !errors.Is(err, errTorrentAlreadyAdded) {
+		t.Errorf("adding an active torrent again returned %v", err)
+	}
+}
+
+// TestVerifyDataWaitsForSlot checks that a resumed torrent isn't verified
+// while another one is.
+func TestVerifyDataWaitsForSlot(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	data, _ := testTorrentFile(t, "Movie.mkv")
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at, err := torrentManager.addTorrentWithMetainfo(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another torrent is being verified.
+	torrentManager.verifySlot <- struct{}{}
+
+	done := make(chan struct{})
+	go func() {
+		torrentManager.verifyData(at)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("torrent was verified while another one was")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	torrentManager.mutex.Lock()
+	verifying, verified := at.verifying, at.verifiedPieces
+	torrentManager.mutex.Unlock()
+
+	if !verifying || verified != 0 {
+		t.Errorf("waiting torrent is verifying %t with %d pieces", verifying, verified)
+	}
+
+	<-torrentManager.verifySlot
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("torrent wasn't verified once the slot was free")
+	}
+
+	if at.verifying || at.verifiedPieces != at.torrent.NumPieces() {
+		t.Errorf("verification ended with verifying %t and %d pieces", at.verifying, at.verifiedPieces)
+	}
+
+	if len(torrentManager.verifySlot) != 0 {
+		t.Error("verification didn't free its slot")
+	}
+}
+
+// waitForStatus waits until the torrent has the status in the database.
+func waitForStatus(t *testing.T, torrentManager *TorrentManager, id string, status model.TorrentStatus) *model.Torrent {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		torrent, err := torrentManager.database.TorrentWithID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if torrent.Status == status {
+			return torrent
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("torrent %s has status %d, expected %d", id, torrent.Status, status)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTorrentManagerSelectFiles(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	data, _ := testTorrentFile(t, "Movie.mkv")
+
+	added, err := torrentManager.AddTorrentFile(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrent := waitForStatus(t, torrentManager, added.ID, model.TorrentStatusSelectingFiles)
+	if len(torrent.Files) != 1 {
+		t.Fatalf("torrent has %d files, expected 1", len(torrent.Files))
+	}
+
+	file := torrent.Files[0]
+	fileIDs, filePaths := []int64{file.ID}, []string{file.Path}
+
+	err = torrentManager.SelectFiles("unknown", fileIDs, filePaths)
+	if !errors.Is(err, errNotSelectingFiles) {
+		t.Errorf("selecting files of an unknown torrent returned %v", err)
+	}
+
+	torrentManager.mutex.Lock()
+	at := torrentManager.activeTorrents[added.ID]
+	previousPaths, previousSize := at.filePaths, at.totalSize
+	torrentManager.mutex.Unlock()
+
+	// A selection that doesn't fit on the disk changes nothing.
+	torrentManager.config.MinFreeSpace = math.MaxInt32
+
+	err = torrentManager.SelectFiles(added.ID, []int64{}, []string{})
+	if !errors.Is(err, errNotEnoughDiskSpace) {
+		t.Errorf("selecting files without disk space returned %v", err)
+	}
+
+	rejected := waitForStatus(t, torrentManager, added.ID, model.TorrentStatusSelectingFiles)
+	if rejected.Files[0].Selected != file.Selected {
+		t.Error("rejected selection was saved")
+	}
+
+	torrentManager.mutex.Lock()
+	paths, size := at.filePaths, at.totalSize
+	torrentManager.mutex.Unlock()
+
+	if !reflect.DeepEqual(paths, previousPaths) || size != previousSize {
+		t.Errorf("rejected selection left %v with %d bytes, expected %v with %d bytes", paths, size, previousPaths, previousSize)
+	}
+
+	torrentManager.config.MinFreeSpace = 0
+
+	err = torrentManager.SelectFiles(added.ID, fileIDs, filePaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrent = waitForStatus(t, torrentManager, added.ID, model.TorrentStatusDownloading)
+	if !torrent.Files[0].Selected {
+		t.Error("selection wasn't saved")
+	}
+
+	err = torrentManager.SelectFiles(added.ID, fileIDs, filePaths)
+	if !errors.Is(err, errNotSelectingFiles) {
+		t.Errorf("selecting files of a downloading torrent returned %v", err)
+	}
+}
+
+func TestTorrentManagerDownloadQueue(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+	torrentManager.config.MaxActiveDownloads = 1
+
+	ids := []string{}
+	for i, status := range []model.TorrentStatus{model.TorrentStatusDownloading, model.TorrentStatusQueued, model.TorrentStatusQueued} {
+		data, _ := testTorrentFile(t, fmt.Sprintf("Movie %d.mkv", i))
+		added, err := torrentManager.AddTorrentFile(data, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitForStatus(t, torrentManager, added.ID, status)
+		ids = append(ids, added.ID)
+	}
+
+	err := torrentManager.MoveTorrentInQueue(ids[0], 1)
+	if err == nil {
+		t.Error("a downloading torrent was moved in the queue")
+	}
+
+	err = torrentManager.MoveTorrentInQueue(ids[2], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := torrentManager.database.GetQueuedTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queued) != 2 || queued[0].ID != ids[2] || queued[1].ID != ids[1] {
+		t.Fatalf("queue is %+v, expected the last torrent first", queued)
+	}
+
+	// Pausing the download frees its slot for the first torrent in the queue.
+	err = torrentManager.PauseTorrent(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, torrentManager, ids[2], model.TorrentStatusDownloading)
+	waitForStatus(t, torrentManager, ids[1], model.TorrentStatusQueued)
+
+	// A resumed torrent goes to the end of the queue while the slot is taken.
+	err = torrentManager.ResumeTorrent(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, torrentManager, ids[0], model.TorrentStatusQueued)
+
+	// Removing the download promotes the next torrent in the queue.
+	torrent, err := torrentManager.database.TorrentWithID(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = torrentManager.database.DeleteTorrent(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrentManager.stopAndRemoveTorrentWithID(ids[2])
+
+	waitForStatus(t, torrentManager, ids[1], model.TorrentStatusDownloading)
+	waitForStatus(t, torrentManager, ids[0], model.TorrentStatusQueued)
+}
+
+func TestTorrentManagerStreamFile(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+
+	data, _ := testTorrentFile(t, "Movie.mkv")
+	added, err := torrentManager.AddTorrentFile(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, torrentManager, added.ID, model.TorrentStatusDownloading)
+
+	torrentManager.mutex.Lock()
+	file := torrentManager.activeTorrents[added.ID].torrent.Files()[0]
+	torrentManager.mutex.Unlock()
+
+	// The priority type of the client isn't exported.
+	checkPriority := func(expected interface{}) {
+		t.Helper()
+
+		torrentManager.mutex.Lock()
+		var priority interface{} = file.Priority()
+		torrentManager.mutex.Unlock()
+
+		if priority != expected {
+			t.Errorf("file priority is %v, expected %v", priority, expected)
+		}
+	}
+
+	_, err = torrentManager.StreamFile(added.ID, "Other.mkv")
+	if err == nil {
+		t.Error("a file that isn't in the torrent was streamed")
+	}
+
+	first, err := torrentManager.StreamFile(added.ID, file.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := torrentManager.StreamFile(added.ID, file.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkPriority(torrent.PiecePriorityHigh)
+
+	// The file stays prioritized while another reader is open and closing a
+	// reader twice doesn't count twice.
+	first.Close()
+	first.Close()
+	checkPriority(torrent.PiecePriorityHigh)
+
+	second.Close()
+	checkPriority(torrent.PiecePriorityNormal)
+
+	// Streaming would download the paused torrent anyway.
+	err = torrentManager.PauseTorrent(added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = torrentManager.StreamFile(added.ID, file.Path())
+	if err == nil {
+		t.Error("a paused torrent was streamed")
+	}
+
+	err = torrentManager.ResumeTorrent(added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrentManager.SetDiskSpaceLow(true)
+
+	_, err = torrentManager.StreamFile(added.ID, file.Path())
+	if err == nil {
+		t.Error("a torrent was streamed while the disk is almost full")
+	}
+
+	torrentManager.SetDiskSpaceLow(false)
+
+	// A download that is paused during playback stays paused.
+	reader, err := torrentManager.StreamFile(added.ID, file.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = torrentManager.PauseTorrent(added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader.Close()
+	checkPriority(torrent.PiecePriorityNone)
+}
+
+func TestTorrentManagerStatsOfSeedingTorrent(t *testing.T) {
+	torrentManager := newTestTorrentManager(t)
+	torrentManager.config.Seeding = true
+	torrentManager.config.SeedRatio = 1
+
+	at, _ := addSeedingTorrent(t, torrentManager, "Movie.mkv")
+
+	for _, status := range []model.TorrentStatus{model.TorrentStatusRendering, model.TorrentStatusReady} {
+		err := torrentManager.database.SetStatusForTorrent(status, at.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := torrentManager.GetTorrentStats(at.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Status != status {
+			t.Errorf("status of the seeding torrent is %d, expected %d", stats.Status, status)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// transferRateWindow is the period over which the transfer rates are
+// averaged.
+const transferRateWindow = 20 * time.Second
+
+type transferSample struct {
+	time       time.Time
+	downloaded int64
+	uploaded   int64
+}
+
+// transferRate averages the rates over the samples of the last window, the
+// samples are taken every second.
+type transferRate struct {
+	samples []transferSample
+}
+
+func (tr *transferRate) add(now time.Time, downloaded int64, uploaded int64) {
+	tr.samples = append(tr.samples, transferSample{time: now, downloaded: downloaded, uploaded: uploaded})
+
+	cutoff := now.Add(-transferRateWindow)
+	for len(tr.samples) > 2 && tr.samples[1].time.Before(cutoff) {
+		tr.samples = tr.samples[1:]
+	}
+}
+
+// rates returns the download and upload rate in bytes/s.
+func (tr *transferRate) rates() (float64, float64) {
+	if len(tr.samples) < 2 {
+		return 0, 0
+	}
+
+	first := tr.samples[0]
+	last := tr.samples[len(tr.samples)-1]
+
+	elapsed := last.time.Sub(first.time).Seconds()
+	if elapsed <= 0 {
+		return 0, 0
+	}
+
+	return float64(last.downloaded-first.downloaded) / elapsed, float64(last.uploaded-first.uploaded) / elapsed
+}
+
+// estimatedTimeLeft returns the seconds needed to transfer the remaining
+// bytes at the rate, nil if it never finishes at that rate.
+func estimatedTimeLeft(remaining int64, rate float64) *int64 {
+	if remaining <= 0 {
+		eta := int64(0)
+		return &eta
+	}
+
+	if rate < 1 {
+		return nil
+	}
+
+	eta := int64(float64(remaining) / rate)
+
+	return &eta
+}
+
+type peerCounter struct {
+	client     string
+	downloaded int64
+	requested  int64
+	rate       transferRate
+}
+
+// peerTraffic counts the data received from each peer and keeps its client
+// name through the client callbacks because the torrent client doesn't
+// export them safely. It doesn't report the data sent to a peer either, so the
+// upload is estimated from the data the peer requested and didn't cancel.
+type peerTraffic struct {
+	mutex sync.Mutex
+	peers map[*torrent.Peer]*peerCounter
+}
+
+func newPeerTraffic() *peerTraffic {
+	return &peerTraffic{
+		peers: map[*torrent.Peer]*peerCounter{},
+	}
+}
+
+func (pt *peerTraffic) register(cfg *torrent.ClientConfig) {
+	cfg.Callbacks.ReceivedUsefulData = append(cfg.Callbacks.ReceivedUsefulData, pt.received)
+	cfg.Callbacks.PeerClosed = append(cfg.Callbacks.PeerClosed, pt.closed)
+	cfg.Callbacks.ReadExtendedHandshake = pt.handshake
+
+	// Requests are rejected when nothing is uploaded.
+	if !cfg.NoUpload {
+		cfg.Callbacks.ReadMessage = pt.readMessage
+	}
+}
+
+// counter has to be called with the mutex held.
+func (pt *peerTraffic) counter(peer *torrent.Peer) *peerCounter {
+	counter, ok := pt.peers[peer]
+	if !ok {
+		counter = &peerCounter{}
+		pt.peers[peer] = counter
+	}
+
+	return counter
+}
+
+func (pt *peerTraffic) received(event torrent.ReceivedUsefulDataEvent) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.counter(event.Peer).downloaded += int64(len(event.Message.Piece))
+}
+
+func (pt *peerTraffic) readMessage(peerConn *torrent.PeerConn, message *pp.Message) {
+	var requested int64
+
+	switch message.Type {
+	case pp.Request:
+		requested = int64(message.Length)
+	case pp.Cancel:
+		requested = -int64(message.Length)
+	default:
+		return
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.counter(&peerConn.Peer).requested += requested
+}
+
+func (pt *peerTraffic) handshake(peerConn *torrent.PeerConn, message *pp.ExtendedHandshakeMessage) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.counter(&peerConn.Peer).client = message.V
+}
+
+func (pt *peerTraffic) closed(peer *torrent.Peer) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	delete(pt.peers, peer)
+}
+
+func (pt *peerTraffic) sample(now time.Time) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	for _, counter := range pt.peers {
+		counter.rate.add(now, counter.downloaded, counter.requested)
+	}
+}
+
+func (pt *peerTraffic) clientName(peer *torrent.Peer) string {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	counter, ok := pt.peers[peer]
+	if !ok {
+		return ""
+	}
+
+	return counter.client
+}
+
+// rates returns the download and the estimated upload rate of the peer in
+// bytes/s.
+func (pt *peerTraffic) rates(peer *torrent.Peer) (float64, float64) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	counter, ok := pt.peers[peer]
+	if !ok {
+		return 0, 0
+	}
+
+	return counter.rate.rates()
+}
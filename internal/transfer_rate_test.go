@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+func TestPeerTrafficRates(t *testing.T) {
+	peerTraffic := newPeerTraffic()
+	peerConn := &torrent.PeerConn{}
+	peer := &peerConn.Peer
+
+	peerTraffic.handshake(peerConn, &pp.ExtendedHandshakeMessage{V: "Client 1.0"})
+
+	now := time.Now()
+	peerTraffic.sample(now)
+
+	peerTraffic.received(torrent.ReceivedUsefulDataEvent{Peer: peer, Message: &pp.Message{Type: pp.Piece, Piece: make([]byte, 2048)}})
+	peerTraffic.readMessage(peerConn, &pp.Message{Type: pp.Request, Length: 4096})
+	peerTraffic.readMessage(peerConn, &pp.Message{Type: pp.Request, Length: 4096})
+	peerTraffic.readMessage(peerConn, &pp.Message{Type: pp.Cancel, Length: 4096})
+	peerTraffic.readMessage(peerConn, &pp.Message{Type: pp.Interested})
+
+	peerTraffic.sample(now.Add(2 * time.Second))
+
+	download, upload := peerTraffic.rates(peer)
+	if download != 1024 || upload != 2048 {
+		t.Errorf("rates are %.0f/%.0f bytes/s, expected 1024/2048", download, upload)
+	}
+
+	if client := peerTraffic.clientName(peer); client != "Client 1.0" {
+		t.Errorf("client name is %q", client)
+	}
+
+	peerTraffic.closed(peer)
+
+	download, upload = peerTraffic.rates(peer)
+	if download != 0 || upload != 0 {
+		t.Errorf("rates of a closed peer are %.0f/%.0f bytes/s", download, upload)
+	}
+}
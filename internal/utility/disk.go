@@ -0,0 +1,16 @@
+package utility
+
+import "syscall"
+
+// FreeSpace returns the number of bytes available to unprivileged users on
+// the filesystem that contains the path.
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
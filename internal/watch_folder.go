@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFolderSettleTime is how long a file has to stay unchanged before it is
+// processed so that files which are still being written aren't picked up.
+const watchFolderSettleTime = 2 * time.Second
+
+// watchFolder adds the .torrent files and the text files with magnet links
+// that are put into the watched directory. Processed files are moved to the
+// done or failed subdirectories.
+type watchFolder struct {
+	path           string
+	torrentManager *TorrentManager
+	watcher        *fsnotify.Watcher
+	pending        map[string]*time.Timer
+	settled        chan string
+}
+
+func newWatchFolder(path string, torrentManager *TorrentManager) *watchFolder {
+	return &watchFolder{
+		path:           path,
+		torrentManager: torrentManager,
+		pending:        map[string]*time.Timer{},
+		settled:        make(chan string),
+	}
+}
+
+func (wf *watchFolder) start() error {
+	for _, dir := range []string{wf.path, wf.doneDir(), wf.failedDir()} {
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	err = watcher.Add(wf.path)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	wf.watcher = watcher
+
+	go wf.run()
+
+	return nil
+}
+
+func (wf *watchFolder) run() {
+	// Pick up the files that were added while piflix wasn't running.
+	entries, err := ioutil.ReadDir(wf.path)
+	if err != nil {
+		log.Println("Couldn't read watch folder", wf.path, "Error:", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && isWatchedFile(entry.Name()) {
+			wf.schedule(filepath.Join(wf.path, entry.Name()))
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-wf.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 || !isWatchedFile(event.Name) {
+				continue
+			}
+
+			wf.schedule(event.Name)
+		case path := <-wf.settled:
+			delete(wf.pending, path)
+			wf.processFile(path)
+		case err, ok := <-wf.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Println("Watch folder error:", err)
+		}
+	}
+}
+
+// schedule (re)starts the timer after which the file is processed.
+func (wf *watchFolder) schedule(path string) {
+	if timer, ok := wf.pending[path]; ok {
+		timer.Reset(watchFolderSettleTime)
+		return
+	}
+
+	wf.pending[path] = time.AfterFunc(watchFolderSettleTime, func() {
+		wf.settled <- path
+	})
+}
+
+func (wf *watchFolder) processFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return
+	}
+
+	log.Println("Adding torrents from watch folder file", path)
+
+	err = wf.addTorrents(path)
+	if err != nil {
+		log.Println("Couldn't add torrents from", path, "Error:", err)
+		wf.moveFile(path, wf.failedDir())
+
+		errorPath := filepath.Join(wf.failedDir(), filepath.Base(path)+".error")
+		err = ioutil.WriteFile(errorPath, []byte(err.Error()+"\n"), 0644)
+		if err != nil {
+			log.Println("Couldn't write error file", errorPath, "Error:", err)
+		}
+
+		return
+	}
+
+	wf.moveFile(path, wf.doneDir())
+}
+
+func (wf *watchFolder) addTorrents(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".torrent") {
+		_, err = wf.torrentManager.AddTorrentFile(data, false)
+		return err
+	}
+
+	var errorMessages []string
+	magnets := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "magnet:") {
+			continue
+		}
+
+		magnets++
+
+		_, err = wf.torrentManager.AddMagnet(line, false)
+		if err != nil {
+			errorMessages = append(errorMessages, line+": "+err.Error())
+		}
+	}
+
+	if magnets == 0 {
+		return errors.New("no magnet links found")
+	}
+
+	if len(errorMessages) > 0 {
+		return errors.New(strings.Join(errorMessages, "\n"))
+	}
+
+	return nil
+}
+
+func (wf *watchFolder) moveFile(path string, dir string) {
+	err := os.Rename(path, filepath.Join(dir, filepath.Base(path)))
+	if err != nil {
+		log.Println("Couldn't move", path, "to", dir, "Error:", err)
+	}
+}
+
+func (wf *watchFolder) doneDir() string {
+	return filepath.Join(wf.path, "done")
+}
+
+func (wf *watchFolder) failedDir() string {
+	return filepath.Join(wf.path, "failed")
+}
+
+func isWatchedFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".torrent", ".magnet", ".txt":
+		return true
+	default:
+		return false
+	}
+}